@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"k-cms/utils"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const commandUsage = `Usage:
+  main jwt-keys list                        登録済みのJWT署名鍵を一覧表示
+  main jwt-keys rotate [-alg HS256|EdDSA]   新しい署名鍵を生成してアクティブにする
+  main jwt-keys retire <kid>                previous 状態の鍵を退役させる
+  main analytics rollup [-from YYYY-MM-DD] [-to YYYY-MM-DD]
+                                            PV数・いいね数の日次集計をやり直す（デフォルトは直近30日）
+  main likes reconcile [-dry-run] [-article ID]
+                                            記事の like_count をいいねの件数と照合して修正する
+`
+
+// runCommand は管理コマンドを実行し、終了コードを返す。
+func runCommand(args []string) int {
+	switch args[0] {
+	case "jwt-keys":
+		return runJWTKeysCommand(args[1:])
+	case "analytics":
+		return runAnalyticsCommand(args[1:])
+	case "likes":
+		return runLikesCommand(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+func runJWTKeysCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := utils.ListJWTKeys()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "鍵一覧の取得に失敗しました: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KID\tALGORITHM\tSTATUS\tCREATED\tRETIRED")
+		for _, k := range keys {
+			retired := "-"
+			if k.RetiredAt != nil {
+				retired = k.RetiredAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.Kid, k.Algorithm, k.Status, k.CreatedAt.Format(time.RFC3339), retired)
+		}
+		w.Flush()
+		return 0
+
+	case "rotate":
+		fs := flag.NewFlagSet("jwt-keys rotate", flag.ContinueOnError)
+		alg := fs.String("alg", "HS256", "署名アルゴリズム (HS256 or EdDSA)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		key, err := utils.RotateJWTKey(*alg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "鍵のローテーションに失敗しました: %v\n", err)
+			return 1
+		}
+		fmt.Printf("新しい署名鍵をアクティブにしました: kid=%s alg=%s\n", key.Kid, key.Algorithm)
+		fmt.Println("以前の鍵で署名されたトークンは retire するまで有効です。")
+		return 0
+
+	case "retire":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, commandUsage)
+			return 2
+		}
+		if err := utils.RetireJWTKey(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "鍵の退役に失敗しました (kid=%s): %v\n", args[1], err)
+			return 1
+		}
+		fmt.Printf("鍵を退役させました: kid=%s\n", args[1])
+		return 0
+
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+func runAnalyticsCommand(args []string) int {
+	if len(args) == 0 || args[0] != "rollup" {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("analytics rollup", flag.ContinueOnError)
+	fromStr := fs.String("from", time.Now().AddDate(0, 0, -29).Format("2006-01-02"), "集計を始める日付")
+	toStr := fs.String("to", time.Now().Format("2006-01-02"), "集計を終える日付")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	from, err := time.ParseInLocation("2006-01-02", *fromStr, time.Local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "無効な日付形式です: %s\n", *fromStr)
+		return 2
+	}
+	to, err := time.ParseInLocation("2006-01-02", *toStr, time.Local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "無効な日付形式です: %s\n", *toStr)
+		return 2
+	}
+
+	if err := utils.RollupDailyStats(from, to); err != nil {
+		fmt.Fprintf(os.Stderr, "日次集計に失敗しました: %v\n", err)
+		return 1
+	}
+	fmt.Printf("日次集計をやり直しました: %s 〜 %s\n", *fromStr, *toStr)
+	return 0
+}
+
+func runLikesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "reconcile" {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("likes reconcile", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "修正せずに不一致だけを表示する")
+	articleID := fs.String("article", "", "照合する記事のID（省略時は全記事）")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	result, err := utils.ReconcileLikeCounts(*dryRun, *articleID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "いいね数の照合に失敗しました: %v\n", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ARTICLE\tSTORED\tACTUAL\tTITLE")
+	for _, d := range result.Discrepancies {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", d.ArticleID, d.Stored, d.Actual, d.Title)
+	}
+	w.Flush()
+	fmt.Printf("照合した記事: %d 不一致: %d 修正: %d\n", result.Checked, result.Mismatched, result.Fixed)
+	if result.Truncated {
+		fmt.Printf("不一致が多いため先頭の%d件のみ表示しています\n", len(result.Discrepancies))
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnv は環境変数の値を返す。未設定の場合はデフォルト値を返す。
+func GetEnv(key, defaultValue string) string {
+	return getEnvWithDefault(key, defaultValue)
+}
+
+// GetEnvInt は環境変数を整数として返す。未設定・不正な値の場合はデフォルト値を返す。
+func GetEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: %s の値が不正です (%q)。デフォルト値 %d を使用します", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// GetEnvBool は環境変数を真偽値として返す。未設定・不正な値の場合はデフォルト値を返す。
+func GetEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: %s の値が不正です (%q)。デフォルト値 %v を使用します", key, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// GetEnvDuration は環境変数を time.Duration（例: "30s", "15m"）として返す。
+// 未設定・不正な値の場合はデフォルト値を返す。
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: %s の値が不正です (%q)。デフォルト値 %v を使用します", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
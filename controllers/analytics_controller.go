@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"errors"
+	"k-cms/config"
+	"k-cms/models"
+	"k-cms/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxTopArticles = 100
+
+// parseStatsRange はクエリ from / to（YYYY-MM-DD）を解釈する。
+// 省略時は to が今日、from は interval に応じて直近30日・12週・12か月とする。
+func parseStatsRange(c *gin.Context, interval string) (from, to time.Time, ok bool) {
+	to = time.Now()
+	if v := c.Query("to"); v != "" {
+		t, err := parseDateParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な日付形式です"})
+			return from, to, false
+		}
+		to = t
+	}
+
+	switch interval {
+	case utils.StatsIntervalWeek:
+		from = to.AddDate(0, 0, -7*11)
+	case utils.StatsIntervalMonth:
+		from = to.AddDate(0, -11, 0)
+	default:
+		from = to.AddDate(0, 0, -29)
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := parseDateParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な日付形式です"})
+			return from, to, false
+		}
+		from = t
+	}
+	return from, to, true
+}
+
+func respondStatsTimeSeries(c *gin.Context, articleID string) {
+	interval := c.DefaultQuery("interval", utils.StatsIntervalDay)
+	from, to, ok := parseStatsRange(c, interval)
+	if !ok {
+		return
+	}
+
+	points, err := utils.StatsTimeSeries(articleID, interval, from, to)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidStatsRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"article_id": articleID,
+		"interval":   interval,
+		"from":       from.Format("2006-01-02"),
+		"to":         to.Format("2006-01-02"),
+		"data":       points,
+	})
+}
+
+// GetArticleStats は記事の PV数・いいね数の時系列を返します
+// クエリ: interval (day|week|month), from, to (YYYY-MM-DD)
+func GetArticleStats(c *gin.Context) {
+	var article models.Article
+	if err := config.DB.Select("id").Where("id = ?", c.Param("id")).First(&article).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+	respondStatsTimeSeries(c, article.ID.String())
+}
+
+// GetSiteStats はサイト全体の PV数・いいね数の時系列を返します
+// クエリ: interval (day|week|month), from, to (YYYY-MM-DD)
+func GetSiteStats(c *gin.Context) {
+	respondStatsTimeSeries(c, "")
+}
+
+// GetTopArticles は期間内の PV数またはいいね数の多い記事を返します
+// クエリ: metric (views|likes), from, to (YYYY-MM-DD、デフォルトは直近30日), limit (デフォルト10)
+func GetTopArticles(c *gin.Context) {
+	from, to, ok := parseStatsRange(c, utils.StatsIntervalDay)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	if limit > maxTopArticles {
+		limit = maxTopArticles
+	}
+	metric := c.DefaultQuery("metric", "views")
+
+	top, err := utils.TopArticles(from, to, metric, limit)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidStatsRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch top articles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metric": metric,
+		"from":   from.Format("2006-01-02"),
+		"to":     to.Format("2006-01-02"),
+		"data":   top,
+	})
+}
+
+func respondTrafficBreakdown(c *gin.Context, articleID string) {
+	from, to, ok := parseStatsRange(c, utils.StatsIntervalDay)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	if limit > maxTopArticles {
+		limit = maxTopArticles
+	}
+	dimension := c.DefaultQuery("dimension", "source")
+
+	rows, err := utils.TrafficBreakdown(articleID, dimension, from, to, limit)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidStatsRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch traffic breakdown"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"article_id": articleID,
+		"dimension":  dimension,
+		"from":       from.Format("2006-01-02"),
+		"to":         to.Format("2006-01-02"),
+		"data":       rows,
+	})
+}
+
+// GetArticleTrafficBreakdown は記事の PV を流入元・キャンペーン・端末などの項目ごとに数えて返します
+// クエリ: dimension (source|referrer|utm_source|utm_medium|utm_campaign|device|browser|language), from, to, limit
+func GetArticleTrafficBreakdown(c *gin.Context) {
+	var article models.Article
+	if err := config.DB.Select("id").Where("id = ?", c.Param("id")).First(&article).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+	respondTrafficBreakdown(c, article.ID.String())
+}
+
+// GetSiteTrafficBreakdown はサイト全体の PV を流入元・キャンペーン・端末などの項目ごとに数えて返します
+// クエリは GetArticleTrafficBreakdown と同じ
+func GetSiteTrafficBreakdown(c *gin.Context) {
+	respondTrafficBreakdown(c, "")
+}
@@ -21,6 +21,7 @@ type ArticleInput struct {
 	Tags          []string `json:"tags" binding:"required"`
 	Datetime      string   `json:"datetime" binding:"required"`
 	Content       string   `json:"content" binding:"required"`
+	Status        string   `json:"status" binding:"omitempty,oneof=draft published"` // 省略時は新規作成なら published、更新なら変更しない
 }
 
 type ArticlesResponse struct {
@@ -28,6 +29,8 @@ type ArticlesResponse struct {
 	Title     string `json:"title"`
 	Excerpt   string `json:"excerpt"`
 	LikeCount int    `json:"like_count"`
+
+	Reactions map[string]int64 `json:"reactions" gorm:"-"` // 種類ごとのリアクション数
 }
 
 type ArticleResponse struct {
@@ -40,6 +43,8 @@ type ArticleResponse struct {
 	Datetime      string   `json:"datetime"`
 	Content       string   `json:"content"`
 	LikeCount     int      `json:"like_count"`
+
+	Reactions map[string]int64 `json:"reactions"` // 種類ごとのリアクション数
 }
 
 func GetArticles(c *gin.Context) {
@@ -49,8 +54,10 @@ func GetArticles(c *gin.Context) {
 
 	// 改善: Select で必要なカラムだけに絞り、Scan で直接 response に入れる
 	// これにより本文 (Content) などの重いデータを読み込まない
+	// 下書きは公開しない（プレビューリンクからのみ閲覧できる）
 	if err := config.DB.Model(&models.Article{}).
 		Select("id as article_id, title, excerpt, like_count").
+		Where("status = ?", models.ArticleStatusPublished).
 		Order("datetime desc"). // 日付順のソートを追加
 		Scan(&response).Error; err != nil {
 		log.Printf("GetArticles: Database error: %v", err)
@@ -58,6 +65,24 @@ func GetArticles(c *gin.Context) {
 		return
 	}
 
+	// リアクション数をまとめて取得して各記事に付ける
+	ids := make([]string, len(response))
+	for i, a := range response {
+		ids[i] = a.ArticleID
+	}
+	reactions, err := utils.ReactionCounts(ids)
+	if err != nil {
+		log.Printf("GetArticles: Failed to count reactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch articles"})
+		return
+	}
+	for i := range response {
+		response[i].Reactions = reactions[response[i].ArticleID]
+		if response[i].Reactions == nil {
+			response[i].Reactions = map[string]int64{}
+		}
+	}
+
 	log.Printf("GetArticles: Returning %d articles", len(response))
 	c.JSON(http.StatusOK, response)
 }
@@ -68,7 +93,8 @@ func GetArticle(c *gin.Context) {
 	// パスパラメータから記事idを取得
 	id := c.Param("id")
 
-	if err := config.DB.Where("id = ?", id).First(&article).Error; err != nil {
+	// 下書きはプレビューリンク（GET /api/preview/:token）からのみ閲覧できる
+	if err := config.DB.Where("id = ? AND status = ?", id, models.ArticleStatusPublished).First(&article).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
 		return
 	}
@@ -85,6 +111,35 @@ func GetArticle(c *gin.Context) {
 		LikeCount:     article.LikeCount,
 	}
 
+	reactions, err := utils.ArticleReactionCounts(response.ID)
+	if err != nil {
+		log.Printf("GetArticle: Failed to count reactions (id=%s): %v", response.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch article"})
+		return
+	}
+	response.Reactions = reactions
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetDraftArticles はログインユーザーの下書き記事を新しい順に返します（公開APIの一覧には含まれないため）
+func GetDraftArticles(c *gin.Context) {
+	userUUID, err := middlewares.GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var response []ArticlesResponse
+	if err := config.DB.Model(&models.Article{}).
+		Select("id as article_id, title, excerpt, like_count").
+		Where("user_id = ? AND status = ?", userUUID, models.ArticleStatusDraft).
+		Order("updated_at desc").
+		Scan(&response).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch articles"})
+		return
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -111,16 +166,22 @@ func AddArticle(c *gin.Context) {
 		Tags:          models.StringArray(input.Tags), // 直接StringArrayに変換
 		Datetime:      input.Datetime,
 		Content:       input.Content,
+		Status:        input.Status,
 		UserID:        userUUID,
 	}
+	if article.Status == "" {
+		article.Status = models.ArticleStatusPublished
+	}
 
 	if err := config.DB.Create(&article).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create article"})
 		return
 	}
 
-	// Trigger frontend build for article creation
-	utils.TriggerBuild("create", article.ID.String())
+	// Trigger frontend build for article creation（下書きは公開サイトに影響しないのでビルドしない）
+	if article.Status == models.ArticleStatusPublished {
+		utils.TriggerBuild(utils.BuildRequest{Source: "article", Action: "create", ArticleID: article.ID.String(), UserID: &userUUID})
+	}
 
 	config.DB.Preload("User").First(&article, article.ID)
 	c.JSON(http.StatusCreated, article)
@@ -151,6 +212,8 @@ func UpdateArticle(c *gin.Context) {
 		return
 	}
 
+	wasPublished := article.Status == models.ArticleStatusPublished
+
 	article.Title = input.Title
 	article.Excerpt = input.Excerpt
 	article.CoverImageURL = input.CoverImageURL
@@ -158,6 +221,9 @@ func UpdateArticle(c *gin.Context) {
 	article.Tags = models.StringArray(input.Tags)
 	article.Datetime = input.Datetime
 	article.Content = input.Content
+	if input.Status != "" {
+		article.Status = input.Status
+	}
 
 	var t time.Time
 
@@ -178,8 +244,10 @@ func UpdateArticle(c *gin.Context) {
 		return
 	}
 
-	// Trigger frontend build for article update
-	utils.TriggerBuild("update", article.ID.String())
+	// Trigger frontend build for article update（公開中、または下書きに戻した場合のみ）
+	if wasPublished || article.Status == models.ArticleStatusPublished {
+		utils.TriggerBuild(utils.BuildRequest{Source: "article", Action: "update", ArticleID: article.ID.String(), UserID: &userUUID})
+	}
 
 	config.DB.Preload("User").First(&article, article.ID)
 	c.JSON(http.StatusOK, article)
@@ -209,8 +277,12 @@ func DeleteArticle(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "article.delete", "article", article.ID.String(), article, nil)
+
 	// Trigger frontend build for article deletion
-	utils.TriggerBuild("delete", article.ID.String())
+	if article.Status == models.ArticleStatusPublished {
+		utils.TriggerBuild(utils.BuildRequest{Source: "article", Action: "delete", ArticleID: article.ID.String(), UserID: &userUUID})
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Article deleted"})
 }
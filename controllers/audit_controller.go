@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"k-cms/config"
+	"k-cms/middlewares"
+	"k-cms/models"
+	"k-cms/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordAudit はリクエストの実行ユーザー・IP・User-Agent を付けて監査ログを記録する。
+func recordAudit(c *gin.Context, action, targetType, targetID string, before, after interface{}) {
+	entry := utils.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  truncate(c.Request.UserAgent(), 512),
+	}
+	if user, err := middlewares.GetUserFromContext(c); err == nil {
+		entry.ActorID = &user.ID
+		entry.ActorName = user.Username
+	}
+	utils.RecordAudit(entry)
+}
+
+// GetAuditEvents は監査ログをページング付きで返す。
+// クエリ: actor_id, action, target_type, target_id, from, to (YYYY-MM-DD または RFC3339), page, per_page
+func GetAuditEvents(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+
+	query := config.DB.Model(&models.AuditEvent{})
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+	if targetID := c.Query("target_id"); targetID != "" {
+		query = query.Where("target_id = ?", targetID)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な日付形式です"})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な日付形式です"})
+			return
+		}
+		// 日付のみ指定された場合はその日の終わりまでを含める
+		if len(to) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+		query = query.Where("created_at < ?", t)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("created_at desc").Limit(perPage).Offset(offset).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedResponse(events, total, page, perPage))
+}
+
+// parseDateParam は RFC3339 または YYYY-MM-DD（ローカルタイムゾーン）の日付を解釈する。
+func parseDateParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
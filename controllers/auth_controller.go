@@ -4,14 +4,16 @@ import (
 	"k-cms/config"
 	"k-cms/middlewares"
 	"k-cms/models"
+	"k-cms/utils"
 	"log"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid/v5"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type LoginInput struct {
@@ -23,6 +25,7 @@ type RegisterInput struct {
 	Username string `json:"username" binding:"required"`
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Role     string `json:"role"` // admin / editor（省略時は従来どおり admin）
 }
 
 func Login(c *gin.Context) {
@@ -34,40 +37,185 @@ func Login(c *gin.Context) {
 	}
 
 	// ユーザーの存在チェック
+	// ユーザーの有無・ロック状態・パスワード不一致のいずれも同じレスポンスを返し、
+	// ユーザー名の存在を推測できないようにする
 	var user models.User
 	if err := config.DB.Where("username = ?", input.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid Credentials"})
+		// 応答時間からユーザーの有無が分からないようダミーのハッシュと比較する
+		utils.VerifyPassword(dummyPasswordHash(), input.Password)
+		recordLoginAttempt(c, input.Username, nil, false, "unknown_user")
 		log.Printf("ログイン失敗 (ユーザー不在): username=%v ip=%s", input.Username, c.ClientIP())
+		respondInvalidCredentials(c)
 		return
 	}
 
-	// パスワードの検証
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Credentials"})
-		log.Printf("ログイン失敗 (パスワード不一致): username=%v ip=%s", input.Username, c.ClientIP())
+	// ロック中のアカウントはパスワードを検証しない
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		// 応答時間からロック中であることが分からないよう、ここでもダミーのハッシュと比較する
+		utils.VerifyPassword(dummyPasswordHash(), input.Password)
+		recordLoginAttempt(c, input.Username, &user.ID, false, "locked")
+		log.Printf("ログイン失敗 (アカウントロック中): username=%v ip=%s locked_until=%v", input.Username, c.ClientIP(), user.LockedUntil)
+		respondInvalidCredentials(c)
 		return
 	}
 
-	// JWTトークンを生成
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	// パスワードの検証（bcrypt / Argon2id どちらのハッシュにも対応）
+	ok, needsRehash, err := utils.VerifyPassword(user.Password, input.Password)
+	if err != nil {
+		log.Printf("パスワード検証エラー: username=%v err=%v", input.Username, err)
+	}
+	if !ok {
+		registerLoginFailure(&user)
+		recordLoginAttempt(c, input.Username, &user.ID, false, "bad_password")
+		log.Printf("ログイン失敗 (パスワード不一致): username=%v ip=%s failed_count=%d", input.Username, c.ClientIP(), user.FailedLoginCount)
+		respondInvalidCredentials(c)
+		return
+	}
+
+	// 旧方式（bcrypt）や古いパラメータのハッシュは、平文が手元にあるこのタイミングで再ハッシュする
+	if needsRehash {
+		if hashed, err := utils.HashPassword(input.Password); err == nil {
+			if err := config.DB.Model(&user).Update("password", hashed).Error; err != nil {
+				log.Printf("パスワードの再ハッシュに失敗: user_id=%s err=%v", user.ID, err)
+			}
+		}
+	}
+
+	// 成功したら失敗カウンタとロックをリセット
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		config.DB.Model(&user).Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil})
+	}
+	recordLoginAttempt(c, input.Username, &user.ID, true, "success")
+
+	if err := issueAuthCookie(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"message": "login successful",
+	})
+}
+
+// issueAuthCookie はJWTトークンを生成し、auth_token Cookie にセットする。
+func issueAuthCookie(c *gin.Context, user models.User) error {
+	// JWTトークンを生成（アクティブな鍵で署名し、kid をヘッダーに付与）
+	tokenString, err := utils.SignAuthToken(jwt.MapClaims{
 		"user_id": user.ID.String(),
+		// パスワード再設定などで世代が上がると、それ以前のトークンは無効になる
+		"ver": user.TokenVersion,
 		// トークン有効期限を7日間に設定
 		"exp": time.Now().Add(time.Hour * 24 * 7).Unix(),
 	})
-
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
+		return err
 	}
 
 	// HttpOnly Cookieをセット
 	c.SetSameSite(http.SameSiteLaxMode)
 	c.SetCookie("auth_token", tokenString, 3600*24*7, "/", "www.katori.dev", true, true)
+	return nil
+}
 
-	c.JSON(http.StatusOK, gin.H{
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHashStr  string
+)
+
+// dummyPasswordHash は存在しないユーザーへのログイン時に比較するダミーのハッシュを返す。
+// 環境変数の読み込み後に現在のハッシュ方式で生成する。
+func dummyPasswordHash() string {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHashStr, _ = utils.HashPassword("k-cms-dummy-password")
+	})
+	return dummyPasswordHashStr
+}
+
+func respondInvalidCredentials(c *gin.Context) {
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Credentials"})
+}
+
+// registerLoginFailure は失敗回数をインクリメントし、しきい値を超えたらアカウントをロックする。
+func registerLoginFailure(user *models.User) {
+	if err := config.DB.Model(user).Update("failed_login_count", gorm.Expr("failed_login_count + ?", 1)).Error; err != nil {
+		log.Printf("ログイン失敗回数の更新に失敗: user_id=%s err=%v", user.ID, err)
+		return
+	}
+	if err := config.DB.Select("failed_login_count").First(user, "id = ?", user.ID).Error; err != nil {
+		return
+	}
+
+	if d := utils.LoginLockoutDuration(user.FailedLoginCount); d > 0 {
+		lockedUntil := time.Now().Add(d)
+		config.DB.Model(user).Update("locked_until", &lockedUntil)
+		log.Printf("アカウントをロックしました: user_id=%s failed_count=%d locked_until=%v", user.ID, user.FailedLoginCount, lockedUntil)
+	}
+}
+
+// recordLoginAttempt はログイン試行を login_attempts テーブルに記録する。
+func recordLoginAttempt(c *gin.Context, username string, userID *uuid.UUID, success bool, reason string) {
+	attempt := models.LoginAttempt{
+		Username:  username,
+		UserID:    userID,
+		IPAddress: c.ClientIP(),
+		UserAgent: truncate(c.Request.UserAgent(), 512),
+		Success:   success,
+		Reason:    reason,
+	}
+	if err := config.DB.Create(&attempt).Error; err != nil {
+		log.Printf("ログイン試行の記録に失敗: username=%v err=%v", username, err)
+	}
+}
+
+// Register は新しいユーザーを作成する（ログイン済みユーザーのみ実行可能）
+func Register(c *gin.Context) {
+	var input RegisterInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = models.RoleAdmin
+	}
+	if !models.IsValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	if err := utils.ValidatePasswordPolicy(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードのハッシュ化に失敗しました"})
+		return
+	}
+
+	user := models.User{
+		Username: input.Username,
+		Email:    input.Email,
+		Password: hashedPassword,
+		Role:     input.Role,
+	}
+	if err := config.DB.Create(&user).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	recordAudit(c, "user.create", "user", user.ID.String(), nil, user)
+
+	c.JSON(http.StatusCreated, gin.H{
 		"user_id": user.ID,
-		"message": "login successful",
+		"role":    user.Role,
+		"message": "User created",
 	})
 }
 
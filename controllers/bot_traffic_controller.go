@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// botTrafficQuery は kind（pageview|like）に応じたボット判定済みアクセスの検索条件を返します
+// クエリ: article_id, reason, from, to (YYYY-MM-DD)
+func botTrafficQuery(c *gin.Context, kind string) (*gorm.DB, bool) {
+	var query *gorm.DB
+	switch kind {
+	case "pageview":
+		query = config.DB.Model(&models.PageView{})
+	case "like":
+		// 取り消されたいいねも含めて表示する
+		query = config.DB.Unscoped().Model(&models.Like{})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be pageview or like"})
+		return nil, false
+	}
+	query = query.Where("is_bot = ?", true)
+
+	if articleID := c.Query("article_id"); articleID != "" {
+		query = query.Where("article_id = ?", articleID)
+	}
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Where("bot_reason = ?", reason)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な日付形式です"})
+			return nil, false
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効な日付形式です"})
+			return nil, false
+		}
+		if len(to) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	return query, true
+}
+
+// BotTrafficEntry はボットと判定されたアクセス1件分
+type BotTrafficEntry struct {
+	ID          string    `json:"id"`
+	ArticleID   string    `json:"article_id"`
+	Fingerprint string    `json:"fingerprint"`
+	IPAddress   string    `json:"ip_address"`
+	BotReason   string    `json:"bot_reason"`
+	UserAgent   string    `json:"user_agent"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GetBotTraffic はボットと判定されて公開カウントから除外されたアクセスを新しい順にページング付きで返します
+// クエリ: kind (pageview|like、デフォルト pageview), article_id, reason, from, to, page, per_page
+func GetBotTraffic(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+	kind := c.DefaultQuery("kind", "pageview")
+
+	query, ok := botTrafficQuery(c, kind)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bot traffic"})
+		return
+	}
+
+	entries := []BotTrafficEntry{}
+	if err := query.Select("id, article_id, fingerprint, ip_address, bot_reason, user_agent, created_at").
+		Order("created_at desc").Limit(perPage).Offset(offset).Scan(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bot traffic"})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedResponse(entries, total, page, perPage))
+}
+
+// GetBotTrafficSummary はボットと判定されたアクセスの件数を種類・理由ごとに返します
+// クエリ: article_id, from, to
+func GetBotTrafficSummary(c *gin.Context) {
+	type reasonCount struct {
+		BotReason string `json:"bot_reason"`
+		Count     int64  `json:"count"`
+	}
+
+	summary := gin.H{}
+	for _, kind := range []string{"pageview", "like"} {
+		query, ok := botTrafficQuery(c, kind)
+		if !ok {
+			return
+		}
+		counts := []reasonCount{}
+		if err := query.Select("bot_reason, COUNT(*) AS count").Group("bot_reason").Order("count DESC").Scan(&counts).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bot traffic summary"})
+			return
+		}
+		summary[kind] = counts
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
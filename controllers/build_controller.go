@@ -1,10 +1,20 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/middlewares"
+	"k-cms/models"
 	"k-cms/utils"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
 )
 
 // GetBuildStatus は現在のビルド状態を返します
@@ -12,3 +22,247 @@ func GetBuildStatus(c *gin.Context) {
 	status := utils.GetBuildStatus()
 	c.JSON(http.StatusOK, status)
 }
+
+// GetBuilds はビルド履歴を新しい順にページング付きで返します
+// クエリ: state, trigger_source, page, per_page
+func GetBuilds(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+
+	query := config.DB.Model(&models.Build{})
+	if state := c.Query("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if source := c.Query("trigger_source"); source != "" {
+		query = query.Where("trigger_source = ?", source)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch builds"})
+		return
+	}
+
+	var builds []models.Build
+	if err := query.Order("queued_at desc").Limit(perPage).Offset(offset).Find(&builds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch builds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedResponse(builds, total, page, perPage))
+}
+
+// GetBuild はビルド1件の情報を返します
+func GetBuild(c *gin.Context) {
+	var build models.Build
+	if err := config.DB.Preload("Targets").Where("id = ?", c.Param("id")).First(&build).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		return
+	}
+	c.JSON(http.StatusOK, build)
+}
+
+// GetBuildLog はビルド1件の全ログを返します
+// クエリ after_seq を指定すると、その連番より後の行のみを返します
+// クエリ target を指定すると、そのパイプラインのターゲットが出力した行のみを返します
+func GetBuildLog(c *gin.Context) {
+	var build models.Build
+	if err := config.DB.Select("id").Where("id = ?", c.Param("id")).First(&build).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		return
+	}
+
+	afterSeq, _ := strconv.Atoi(c.DefaultQuery("after_seq", "0"))
+
+	query := config.DB.Where("build_id = ? AND seq > ?", build.ID, afterSeq)
+	if target := c.Query("target"); target != "" {
+		query = query.Where("target = ?", target)
+	}
+
+	var lines []models.BuildLogLine
+	if err := query.Order("seq asc").Find(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"build_id": build.ID, "lines": lines})
+}
+
+// enqueueBuild はリクエストしたユーザーを付けてビルドをキューに積み、まとめられた先のジョブをレスポンスとして返す。
+func enqueueBuild(c *gin.Context, req utils.BuildRequest, auditAction string) {
+	if userID, err := middlewares.GetUserIDFromContext(c); err == nil {
+		req.UserID = &userID
+	}
+
+	job, err := utils.TriggerBuild(req)
+	if err != nil {
+		if errors.Is(err, utils.ErrBuildNotConfigured) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Build is not configured"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trigger build"})
+		return
+	}
+
+	recordAudit(c, auditAction, "build", job.ID, nil, job)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Build queued", "data": job})
+}
+
+// TriggerManualBuild はサイト全体の再ビルドを手動でキューに積みます
+func TriggerManualBuild(c *gin.Context) {
+	enqueueBuild(c, utils.BuildRequest{Source: "manual", Action: "rebuild"}, "build.trigger")
+}
+
+// RetryBuild は失敗またはキャンセルされたビルドを同じ内容で再実行します
+func RetryBuild(c *gin.Context) {
+	var build models.Build
+	if err := config.DB.Where("id = ?", c.Param("id")).First(&build).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		return
+	}
+	if build.State != models.BuildStateFailed && build.State != models.BuildStateCanceled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed or canceled builds can be retried"})
+		return
+	}
+
+	req := utils.BuildRequest{
+		Source:     "retry",
+		Action:     build.Action,
+		ArticleIDs: build.ArticleIDs,
+		RetryOf:    &build.ID,
+	}
+	// まとめられたビルドは "batch" ではなく元のアクションの一覧を引き継ぐ
+	if len(build.Actions) > 0 {
+		req.Action = build.Actions[0]
+		req.Actions = build.Actions
+	}
+	enqueueBuild(c, req, "build.retry")
+}
+
+// CancelBuild は実行待ちまたは実行中のビルドをキャンセルします
+// 実行中の場合はプロセスグループに SIGTERM を送り、猶予期間後も残っていれば SIGKILL します
+func CancelBuild(c *gin.Context) {
+	var canceledBy *uuid.UUID
+	if userID, err := middlewares.GetUserIDFromContext(c); err == nil {
+		canceledBy = &userID
+	}
+
+	buildID := c.Param("id")
+	if err := utils.CancelBuild(buildID, canceledBy); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		case errors.Is(err, utils.ErrBuildNotCancelable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Build is not cancelable"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel build"})
+		}
+		return
+	}
+
+	recordAudit(c, "build.cancel", "build", buildID, nil, nil)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Build cancel requested"})
+}
+
+// ハートビート（SSE のコメント行）の送信間隔。プロキシによるアイドル切断を防ぐ
+const buildStreamHeartbeat = 15 * time.Second
+
+func isTerminalBuildState(state string) bool {
+	return state == models.BuildStateSuccess || state == models.BuildStateFailed || state == models.BuildStateCanceled
+}
+
+// StreamBuild はビルドのログと状態遷移を Server-Sent Events で配信します
+// 再接続時は Last-Event-ID ヘッダー（またはクエリ last_event_id）のログ連番より後から再送します
+// イベント:
+//   - state: {"type":"state","state":"queued|running|success|failed|canceled",...}
+//   - target: {"type":"target","target":"production","state":"queued|running|success|failed|canceled|skipped",...}
+//   - log:   {"type":"log","seq":1,"target":"production","line":"...",...}（id にログ連番を設定）
+func StreamBuild(c *gin.Context) {
+	buildID := c.Param("id")
+
+	var build models.Build
+	if err := config.DB.Select("id").Where("id = ?", buildID).First(&build).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	sentSeq, _ := strconv.Atoi(lastEventID)
+
+	sub, lines, err := utils.SubscribeBuildEvents(build.ID.String(), sentSeq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe build events"})
+		return
+	}
+	defer sub.Close()
+
+	// 購読開始後の状態を取得する（これ以降の遷移はチャネルで届く）
+	if err := config.DB.Preload("Targets").Where("id = ?", build.ID).First(&build).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx のバッファリングを無効化
+	c.Status(http.StatusOK)
+
+	send := func(ev utils.BuildEvent) {
+		event := sse.Event{Event: ev.Type, Data: ev}
+		if ev.Type == utils.BuildEventLog {
+			event.Id = strconv.Itoa(ev.Seq)
+		}
+		c.Render(-1, event)
+		c.Writer.Flush()
+	}
+
+	send(utils.BuildEvent{BuildID: buildID, Type: utils.BuildEventState, Seq: sentSeq, State: build.State, Time: time.Now()})
+	for _, t := range build.Targets {
+		send(utils.BuildEvent{BuildID: buildID, Type: utils.BuildEventTarget, Seq: sentSeq, Target: t.Name, State: t.State, Time: time.Now()})
+	}
+	for _, l := range lines {
+		send(utils.BuildEvent{BuildID: buildID, Type: utils.BuildEventLog, Seq: l.Seq, Target: l.Target, Line: l.Line, Time: l.CreatedAt})
+		sentSeq = l.Seq
+	}
+	if isTerminalBuildState(build.State) {
+		return
+	}
+
+	heartbeat := time.NewTicker(buildStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case ev, ok := <-sub.C:
+			if !ok {
+				// 受信が追いつかず切断された、またはサーバーを終了する。クライアントは Last-Event-ID で再接続する
+				return
+			}
+			if ev.Type == utils.BuildEventLog {
+				if ev.Seq <= sentSeq {
+					continue
+				}
+				sentSeq = ev.Seq
+			}
+			send(ev)
+			if ev.Type == utils.BuildEventState && isTerminalBuildState(ev.State) {
+				return
+			}
+		}
+	}
+}
+
+// TestBuildNotification は設定された通知チャネルにテスト通知を送信し、チャネルごとの結果を返します
+func TestBuildNotification(c *gin.Context) {
+	results := utils.SendTestNotification()
+	recordAudit(c, "notification.test", "notification", "", nil, results)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,447 @@
+package controllers
+
+import (
+	"errors"
+	"k-cms/config"
+	"k-cms/middlewares"
+	"k-cms/models"
+	"k-cms/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 一度にモデレーションできるコメントの最大件数
+const maxCommentModerationBatch = 500
+
+type CommentRequest struct {
+	Fingerprint string `json:"fingerprint" binding:"required"`
+	DisplayName string `json:"display_name"` // 省略時は匿名
+	Body        string `json:"body" binding:"required"`
+	ParentID    string `json:"parent_id"` // 返信先のコメントID（承認済みのもののみ）
+	Webdriver   bool   `json:"webdriver"` // navigator.webdriver（自動操作されたブラウザの判定に使う）
+}
+
+// PublicComment は公開用のコメント。fingerprint や IP アドレスは含めない
+type PublicComment struct {
+	ID          string          `json:"id"`
+	ParentID    *string         `json:"parent_id"`
+	DisplayName string          `json:"display_name"`
+	Body        string          `json:"body"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Replies     []PublicComment `json:"replies"`
+}
+
+// findPublishedArticleForComments はコメントを扱える公開中の記事を取得します（見つからない場合は404を返す）
+func findPublishedArticleForComments(c *gin.Context) *models.Article {
+	var article models.Article
+	if err := config.DB.Select("id").Where("id = ? AND status = ?", c.Param("id"), models.ArticleStatusPublished).First(&article).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return nil
+	}
+	return &article
+}
+
+// GetComments は記事の承認済みコメントをスレッド形式で古い順に返します
+// ページングはスレッドの先頭のコメント単位で行い、返信は全て replies に含めます
+// 親が承認されていない（取り消された）返信は表示しません
+func GetComments(c *gin.Context) {
+	article := findPublishedArticleForComments(c)
+	if article == nil {
+		return
+	}
+	page, perPage, offset := getPagination(c)
+
+	var comments []models.Comment
+	if err := config.DB.Select("id, parent_id, display_name, body, created_at").
+		Where("article_id = ? AND status = ?", article.ID, models.CommentStatusApproved).
+		Order("created_at asc").
+		Find(&comments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		return
+	}
+
+	roots := buildCommentThreads(comments)
+	total := int64(len(roots))
+	if offset > len(roots) {
+		offset = len(roots)
+	}
+	end := offset + perPage
+	if end > len(roots) {
+		end = len(roots)
+	}
+	c.JSON(http.StatusOK, paginatedResponse(roots[offset:end], total, page, perPage))
+}
+
+// buildCommentThreads は created_at の昇順に並んだコメントを返信の木に組み立て、スレッドの先頭の一覧を返します
+func buildCommentThreads(comments []models.Comment) []PublicComment {
+	children := map[string][]models.Comment{}
+	var roots []models.Comment
+	for _, cm := range comments {
+		if cm.ParentID == nil {
+			roots = append(roots, cm)
+		} else {
+			children[cm.ParentID.String()] = append(children[cm.ParentID.String()], cm)
+		}
+	}
+
+	var build func(cm models.Comment) PublicComment
+	build = func(cm models.Comment) PublicComment {
+		pc := PublicComment{
+			ID:          cm.ID.String(),
+			DisplayName: cm.DisplayName,
+			Body:        cm.Body,
+			CreatedAt:   cm.CreatedAt,
+			Replies:     []PublicComment{},
+		}
+		if cm.ParentID != nil {
+			parentID := cm.ParentID.String()
+			pc.ParentID = &parentID
+		}
+		for _, child := range children[pc.ID] {
+			pc.Replies = append(pc.Replies, build(child))
+		}
+		return pc
+	}
+
+	threads := make([]PublicComment, 0, len(roots))
+	for _, root := range roots {
+		threads = append(threads, build(root))
+	}
+	return threads
+}
+
+// CreateComment は記事にコメントを投稿します。投稿されたコメントは承認されるまで公開されません
+// 投稿を禁止された fingerprint からは受け付けず、ボットと判定したものは spam として記録します
+func CreateComment(c *gin.Context) {
+	article := findPublishedArticleForComments(c)
+	if article == nil {
+		return
+	}
+
+	var input CommentRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// フィンガープリントバリデーション
+	if len(input.Fingerprint) < 10 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fingerprint"})
+		return
+	}
+	body := strings.TrimSpace(input.Body)
+	maxLength := config.GetEnvInt("COMMENT_MAX_LENGTH", 2000)
+	if body == "" || utf8.RuneCountInString(body) > maxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment must be between 1 and " + strconv.Itoa(maxLength) + " characters"})
+		return
+	}
+	displayName := strings.TrimSpace(input.DisplayName)
+	if utf8.RuneCountInString(displayName) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Display name must be 50 characters or less"})
+		return
+	}
+
+	var banned int64
+	if err := config.DB.Model(&models.CommentBan{}).Where("fingerprint = ?", input.Fingerprint).Count(&banned).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if banned > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to comment"})
+		return
+	}
+
+	comment := models.Comment{
+		ArticleID:   article.ID,
+		Fingerprint: input.Fingerprint,
+		DisplayName: displayName,
+		Body:        body,
+		Status:      models.CommentStatusPending,
+		IPAddress:   utils.AnonymizeIP(c.ClientIP()), // PRIVACY_IP_MODE に従ってハッシュ化・切り詰めする
+		UserAgent:   truncate(c.Request.UserAgent(), 255),
+	}
+
+	// 返信先は同じ記事の承認済みコメントに限る
+	if input.ParentID != "" {
+		var parent models.Comment
+		if err := config.DB.Select("id").
+			Where("id = ? AND article_id = ? AND status = ?", input.ParentID, article.ID, models.CommentStatusApproved).
+			First(&parent).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent comment not found"})
+			return
+		}
+		comment.ParentID = &parent.ID
+	}
+
+	// ボットらしい投稿も記録はするが、承認待ちには入れない
+	if comment.BotReason = utils.DetectBot(c.Request, input.Fingerprint, input.Webdriver); comment.BotReason != "" {
+		comment.Status = models.CommentStatusSpam
+	}
+
+	if err := config.DB.Create(&comment).Error; err != nil {
+		log.Printf("CreateComment: Database error (article_id=%s): %v", article.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post comment"})
+		return
+	}
+
+	// spam と判定したことは投稿者に伝えない
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":      comment.ID,
+		"status":  models.CommentStatusPending,
+		"message": "Comment submitted for moderation",
+	})
+}
+
+// GetModerationComments はコメントを新しい順にページング付きで返します（モデレーション用）
+// クエリ: status（デフォルト pending、all で全て）, article_id, fingerprint, page, per_page
+func GetModerationComments(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+
+	query := config.DB.Model(&models.Comment{})
+	if status := c.DefaultQuery("status", models.CommentStatusPending); status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if articleID := c.Query("article_id"); articleID != "" {
+		query = query.Where("article_id = ?", articleID)
+	}
+	if fingerprint := c.Query("fingerprint"); fingerprint != "" {
+		query = query.Where("fingerprint = ?", fingerprint)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		return
+	}
+
+	comments := []models.Comment{}
+	if err := query.Preload("Article", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, title")
+	}).Order("created_at desc").Limit(perPage).Offset(offset).Find(&comments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedResponse(comments, total, page, perPage))
+}
+
+type CommentModerationInput struct {
+	IDs     []string `json:"ids" binding:"required,min=1"`
+	Rebuild *bool    `json:"rebuild"` // 公開中のコメントが変わった場合にビルドするか（省略時は COMMENT_REBUILD_ON_APPROVE）
+	Reason  string   `json:"reason"`  // 投稿禁止の理由（ban のみ）
+}
+
+// bindCommentModeration はモデレーション対象のコメントを読み込みます（失敗した場合はレスポンスを書き込み nil を返す）
+func bindCommentModeration(c *gin.Context) (*CommentModerationInput, []models.Comment) {
+	var input CommentModerationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil
+	}
+	if len(input.IDs) > maxCommentModerationBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many comments (max " + strconv.Itoa(maxCommentModerationBatch) + ")"})
+		return nil, nil
+	}
+
+	var comments []models.Comment
+	if err := config.DB.Where("id IN ?", input.IDs).Find(&comments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		return nil, nil
+	}
+	if len(comments) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comments not found"})
+		return nil, nil
+	}
+	return &input, comments
+}
+
+// moderatorID はモデレーションを行ったユーザーのIDを返します
+func moderatorID(c *gin.Context) *uuid.UUID {
+	if id, err := middlewares.GetUserIDFromContext(c); err == nil {
+		return &id
+	}
+	return nil
+}
+
+// triggerCommentRebuild は公開中のコメントが変わった記事のビルドを要求します
+func triggerCommentRebuild(c *gin.Context, rebuild *bool, articleIDs map[string]bool) {
+	enabled := config.GetEnv("COMMENT_REBUILD_ON_APPROVE", "false") == "true"
+	if rebuild != nil {
+		enabled = *rebuild
+	}
+	if !enabled {
+		return
+	}
+	userID := moderatorID(c)
+	for articleID := range articleIDs {
+		utils.TriggerBuild(utils.BuildRequest{Source: "comment", Action: utils.BuildActionComment, ArticleID: articleID, UserID: userID})
+	}
+}
+
+// ApproveComments はコメントをまとめて承認して公開します
+func ApproveComments(c *gin.Context) {
+	input, comments := bindCommentModeration(c)
+	if input == nil {
+		return
+	}
+
+	ids := []uuid.UUID{}
+	changed := map[string]bool{}
+	for _, cm := range comments {
+		if cm.Status != models.CommentStatusApproved {
+			ids = append(ids, cm.ID)
+			changed[cm.ArticleID.String()] = true
+		}
+	}
+	if len(ids) > 0 {
+		if err := config.DB.Model(&models.Comment{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":       models.CommentStatusApproved,
+			"moderated_at": time.Now(),
+			"moderated_by": moderatorID(c),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve comments"})
+			return
+		}
+		recordAudit(c, "comment.approve", "comment", "", nil, gin.H{"ids": ids})
+		triggerCommentRebuild(c, input.Rebuild, changed)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comments approved", "approved": len(ids)})
+}
+
+// RejectComments はコメントをまとめて却下（削除）します。公開中のコメントも取り下げます
+func RejectComments(c *gin.Context) {
+	input, comments := bindCommentModeration(c)
+	if input == nil {
+		return
+	}
+
+	ids := make([]uuid.UUID, len(comments))
+	changed := map[string]bool{}
+	for i, cm := range comments {
+		ids[i] = cm.ID
+		if cm.Status == models.CommentStatusApproved {
+			changed[cm.ArticleID.String()] = true
+		}
+	}
+	if err := config.DB.Where("id IN ?", ids).Delete(&models.Comment{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject comments"})
+		return
+	}
+	recordAudit(c, "comment.reject", "comment", "", nil, gin.H{"ids": ids})
+	triggerCommentRebuild(c, input.Rebuild, changed)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comments rejected", "rejected": len(ids)})
+}
+
+// BanCommenters は指定したコメントの投稿者（fingerprint）のコメントの投稿を禁止し、
+// その投稿者のコメントを全て spam にします（公開中のものも取り下げます）
+func BanCommenters(c *gin.Context) {
+	input, comments := bindCommentModeration(c)
+	if input == nil {
+		return
+	}
+	userID := moderatorID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	fingerprints := []string{}
+	seen := map[string]bool{}
+	for _, cm := range comments {
+		if !seen[cm.Fingerprint] {
+			seen[cm.Fingerprint] = true
+			fingerprints = append(fingerprints, cm.Fingerprint)
+		}
+	}
+
+	changed := map[string]bool{}
+	var spammed int64
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		for _, fp := range fingerprints {
+			ban := models.CommentBan{Fingerprint: fp, Reason: truncate(input.Reason, 255), CreatedBy: *userID}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ban).Error; err != nil {
+				return err
+			}
+		}
+
+		var approvedArticles []string
+		if err := tx.Model(&models.Comment{}).
+			Where("fingerprint IN ? AND status = ?", fingerprints, models.CommentStatusApproved).
+			Distinct().Pluck("article_id", &approvedArticles).Error; err != nil {
+			return err
+		}
+		for _, id := range approvedArticles {
+			changed[id] = true
+		}
+
+		result := tx.Model(&models.Comment{}).
+			Where("fingerprint IN ? AND status <> ?", fingerprints, models.CommentStatusSpam).
+			Updates(map[string]interface{}{
+				"status":       models.CommentStatusSpam,
+				"moderated_at": time.Now(),
+				"moderated_by": userID,
+			})
+		spammed = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ban commenters"})
+		return
+	}
+
+	digests := make([]string, len(fingerprints))
+	for i, fp := range fingerprints {
+		digests[i] = utils.FingerprintDigest(fp)
+	}
+	recordAudit(c, "comment.ban", "fingerprint", "", nil, gin.H{"fingerprints": digests, "reason": input.Reason, "comments_marked_spam": spammed})
+	triggerCommentRebuild(c, input.Rebuild, changed)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Commenters banned", "banned": len(fingerprints), "marked_spam": spammed})
+}
+
+// GetCommentBans は投稿を禁止した fingerprint を新しい順にページング付きで返します
+func GetCommentBans(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+
+	var total int64
+	if err := config.DB.Model(&models.CommentBan{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comment bans"})
+		return
+	}
+	bans := []models.CommentBan{}
+	if err := config.DB.Order("created_at desc").Limit(perPage).Offset(offset).Find(&bans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comment bans"})
+		return
+	}
+	c.JSON(http.StatusOK, paginatedResponse(bans, total, page, perPage))
+}
+
+// DeleteCommentBan は投稿の禁止を解除します（spam にしたコメントは戻しません）
+func DeleteCommentBan(c *gin.Context) {
+	var ban models.CommentBan
+	if err := config.DB.Where("id = ?", c.Param("id")).First(&ban).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment ban not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comment ban"})
+		return
+	}
+	if err := config.DB.Delete(&ban).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment ban"})
+		return
+	}
+	recordAudit(c, "comment.unban", "fingerprint", utils.FingerprintDigest(ban.Fingerprint), nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Comment ban removed"})
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"k-cms/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// カウンターのストリームのハートビート（SSE のコメント行）の送信間隔
+const counterStreamHeartbeat = 25 * time.Second
+
+// StreamArticleCounters は記事1件のいいね数・PV数の更新を Server-Sent Events で配信します
+func StreamArticleCounters(c *gin.Context) {
+	streamCounters(c, []string{c.Param("id")})
+}
+
+// StreamCounters はクエリ ids（カンマ区切り）で指定した記事のいいね数・PV数の更新をまとめて配信します
+// 記事一覧ページなどで1本の接続にまとめるために使います
+func StreamCounters(c *gin.Context) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+	streamCounters(c, ids)
+}
+
+// streamCounters は公開中の記事だけを購読し、接続直後に現在の値を送ってから更新を配信します
+// イベント:
+//   - likes: {"article_id":"...","type":"likes","like_count":3,"reactions":{"like":3,"heart":1},...}
+//   - views: {"article_id":"...","type":"views","view_count":120,...}
+//
+// 受信が追いつかないクライアントはサーバー側で切断するため、クライアントは再接続して最新の値を受け取り直します
+func streamCounters(c *gin.Context, ids []string) {
+	if len(ids) > utils.CounterStreamMaxArticles() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many articles (max %d)", utils.CounterStreamMaxArticles())})
+		return
+	}
+
+	var articles []models.Article
+	if err := config.DB.Select("id, like_count").
+		Where("id IN ? AND status = ?", ids, models.ArticleStatusPublished).
+		Find(&articles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(articles) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+	articleIDs := make([]string, len(articles))
+	for i, a := range articles {
+		articleIDs[i] = a.ID.String()
+	}
+
+	// 購読してから現在の値を取得する（取得中の更新はチャネルで届く）
+	sub, err := utils.SubscribeCounterEvents(articleIDs, c.ClientIP())
+	if errors.Is(err, utils.ErrTooManyCounterStreams) || errors.Is(err, utils.ErrCounterStreamsClosed) {
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many connections"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many connections"})
+		return
+	}
+	defer sub.Close()
+
+	reactions, err := utils.ReactionCounts(articleIDs)
+	if err != nil {
+		log.Printf("streamCounters: Failed to count reactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var current []models.Article
+	if err := config.DB.Select("id, like_count").Where("id IN ?", articleIDs).Find(&current).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx のバッファリングを無効化
+	c.Status(http.StatusOK)
+
+	send := func(ev utils.CounterEvent) {
+		c.Render(-1, sse.Event{Event: ev.Type, Data: ev})
+		c.Writer.Flush()
+	}
+
+	for _, a := range current {
+		id := a.ID.String()
+		likeCount := a.LikeCount
+		counts := reactions[id]
+		if counts == nil {
+			counts = map[string]int64{}
+		}
+		send(utils.CounterEvent{ArticleID: id, Type: utils.CounterEventLikes, LikeCount: &likeCount, Reactions: counts, Time: time.Now()})
+
+		views, err := utils.CachedViewCount(id)
+		if err != nil {
+			log.Printf("streamCounters: Failed to count views (article_id=%s): %v", id, err)
+			continue
+		}
+		send(utils.CounterEvent{ArticleID: id, Type: utils.CounterEventViews, ViewCount: &views, Time: time.Now()})
+	}
+
+	heartbeat := time.NewTicker(counterStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case ev, ok := <-sub.C:
+			if !ok {
+				// 受信が追いつかず切断された、またはサーバーを終了する
+				return
+			}
+			send(ev)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"errors"
+	"k-cms/config"
+	"k-cms/middlewares"
+	"k-cms/models"
+	"k-cms/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// GetDeploys はデプロイ（公開したリリース）の履歴を新しい順にページング付きで返します
+// クエリ: target, status, page, per_page
+func GetDeploys(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+
+	query := config.DB.Model(&models.Deploy{})
+	if target := c.Query("target"); target != "" {
+		query = query.Where("target = ?", target)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deploys"})
+		return
+	}
+
+	var deploys []models.Deploy
+	if err := query.Order("created_at desc").Limit(perPage).Offset(offset).Find(&deploys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deploys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedResponse(deploys, total, page, perPage))
+}
+
+// RollbackDeploy は保持されているリリースを再ビルドせずに公開し直します
+func RollbackDeploy(c *gin.Context) {
+	var userID *uuid.UUID
+	if id, err := middlewares.GetUserIDFromContext(c); err == nil {
+		userID = &id
+	}
+
+	var before models.Deploy
+	if err := config.DB.Where("root IN (?) AND status = ?",
+		config.DB.Model(&models.Deploy{}).Select("root").Where("id = ?", c.Param("id")),
+		models.DeployStatusActive).First(&before).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deploy"})
+		return
+	}
+
+	deploy, err := utils.RollbackDeploy(c.Param("id"), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deploy not found"})
+		case errors.Is(err, utils.ErrDeployNotRollbackable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Release is no longer available"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back"})
+		}
+		return
+	}
+
+	recordAudit(c, "deploy.rollback", "deploy", deploy.ID.String(),
+		gin.H{"active_deploy_id": before.ID, "release_dir": before.ReleaseDir},
+		gin.H{"active_deploy_id": deploy.ID, "release_dir": deploy.ReleaseDir})
+	c.JSON(http.StatusOK, gin.H{"message": "Rolled back", "data": deploy})
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// getPagination はクエリパラメータ page / per_page を解釈し、page・per_page・offset を返す。
+func getPagination(c *gin.Context) (page, perPage, offset int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err = strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage, (page - 1) * perPage
+}
+
+// paginatedResponse は一覧系エンドポイント共通のレスポンス形式を返す。
+func paginatedResponse(data interface{}, total int64, page, perPage int) gin.H {
+	return gin.H{
+		"data":     data,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	}
+}
+
+// truncate は文字列を最大 max バイトに切り詰める（マルチバイト文字の途中では切らない）。
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -179,5 +179,7 @@ func DeleteImage(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "image.delete", "image", image.ID.String(), image, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
 }
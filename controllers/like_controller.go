@@ -3,8 +3,10 @@ package controllers
 import (
 	"k-cms/config"
 	"k-cms/models"
+	"k-cms/utils"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid/v5"
@@ -14,6 +16,7 @@ import (
 type LikeRequest struct {
 	ArticleID   string `json:"article_id" binding:"required"`
 	Fingerprint string `json:"fingerprint" binding:"required"`
+	Webdriver   bool   `json:"webdriver"` // navigator.webdriver（自動操作されたブラウザの判定に使う）
 }
 
 type LikeResponse struct {
@@ -23,74 +26,110 @@ type LikeResponse struct {
 	Message   string `json:"message"`
 }
 
-// いいねを追加または削除
-func ToggleLike(c *gin.Context) {
-	var input LikeRequest
+type ReactionRequest struct {
+	LikeRequest
+	Reaction string `json:"reaction" binding:"required"`
+}
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+type ReactionResponse struct {
+	ArticleID string           `json:"article_id"`
+	Reaction  string           `json:"reaction,omitempty"`
+	Reacted   bool             `json:"reacted"`
+	Reactions map[string]int64 `json:"reactions"` // 種類ごとのリアクション数
+	Mine      []string         `json:"mine"`      // この fingerprint が付けているリアクション
+	LikeCount int              `json:"like_count"`
+	Message   string           `json:"message,omitempty"`
+}
 
-	// 記事の存在確認（IDだけ確認）
-	var article models.Article
-	if err := config.DB.Select("id, like_count").Where("id = ?", input.ArticleID).First(&article).Error; err != nil {
+// toggleReaction は記事へのリアクションを追加または取り消す。
+// ボットと判定したリアクションも記録はするが数えない。like_count は従来のいいね（models.DefaultReaction）のみを数える。
+// 失敗した場合はレスポンスを書き込み ok=false を返す。
+func toggleReaction(c *gin.Context, input LikeRequest, reaction string) (article models.Article, reacted bool, message string, ok bool) {
+	// 公開中の記事かを確認（下書き・非公開の記事にはリアクションできない）
+	if err := config.DB.Select("id, like_count").Where("id = ? AND status = ?", input.ArticleID, models.ArticleStatusPublished).First(&article).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
-		return
+		return article, false, "", false
 	}
 
 	// フィンガープリントバリデーション
 	if len(input.Fingerprint) < 10 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fingerprint"})
-		return
+		return article, false, "", false
 	}
 
-	clientIP := c.ClientIP()
+	clientIP := utils.AnonymizeIP(c.ClientIP()) // PRIVACY_IP_MODE に従ってハッシュ化・切り詰めする
 	articleUUID, _ := uuid.FromString(input.ArticleID)
+	// ボットらしいリアクションも記録はするが、カウントには数えない
+	botReason := utils.DetectBot(c.Request, input.Fingerprint, input.Webdriver)
+	isBot := botReason != ""
+	userAgent := truncate(c.Request.UserAgent(), 255)
+	countsAsLike := reaction == models.DefaultReaction
 
-	var response LikeResponse
+	// like_count を delta だけ増減する（従来のいいね以外は何もしない）
+	updateLikeCount := func(tx *gorm.DB, delta int) error {
+		if !countsAsLike {
+			return nil
+		}
+		return tx.Model(&article).Update("like_count", gorm.Expr("like_count + ?", delta)).Error
+	}
 
 	// トランザクション開始
 	err := config.DB.Transaction(func(tx *gorm.DB) error {
 		var existingLike models.Like
-		err := tx.Unscoped().Where("article_id = ? AND fingerprint = ?", input.ArticleID, input.Fingerprint).First(&existingLike).Error
+		err := tx.Unscoped().Where("article_id = ? AND fingerprint = ? AND reaction = ?", input.ArticleID, input.Fingerprint, reaction).First(&existingLike).Error
 
 		if err == gorm.ErrRecordNotFound {
 			// 新規作成
 			newLike := models.Like{
 				ArticleID:   articleUUID,
 				Fingerprint: input.Fingerprint,
+				Reaction:    reaction,
 				IPAddress:   clientIP,
+				IsBot:       isBot,
+				BotReason:   botReason,
+				UserAgent:   userAgent,
 			}
 			if err := tx.Create(&newLike).Error; err != nil {
 				return err
 			}
 			// カウントアップ
-			if err := tx.Model(&article).Update("like_count", gorm.Expr("like_count + ?", 1)).Error; err != nil {
-				return err
+			if !isBot {
+				if err := updateLikeCount(tx, 1); err != nil {
+					return err
+				}
 			}
-			response = LikeResponse{IsLiked: true, Message: "Like added"}
+			reacted, message = true, "Reaction added"
 		} else if err != nil {
 			return err
 		} else {
 			if existingLike.DeletedAt.Valid {
 				// 復元
-				if err := tx.Unscoped().Model(&existingLike).Update("deleted_at", nil).Error; err != nil {
+				if err := tx.Unscoped().Model(&existingLike).Updates(map[string]interface{}{
+					"deleted_at": nil,
+					"is_bot":     isBot,
+					"bot_reason": botReason,
+					"user_agent": userAgent,
+				}).Error; err != nil {
 					return err
 				}
-				if err := tx.Model(&article).Update("like_count", gorm.Expr("like_count + ?", 1)).Error; err != nil {
-					return err
+				if !isBot {
+					if err := updateLikeCount(tx, 1); err != nil {
+						return err
+					}
 				}
-				response = LikeResponse{IsLiked: true, Message: "Like restored"}
+				reacted, message = true, "Reaction restored"
 			} else {
 				// 削除
 				if err := tx.Delete(&existingLike).Error; err != nil {
 					return err
 				}
-				if err := tx.Model(&article).Update("like_count", gorm.Expr("like_count - ?", 1)).Error; err != nil {
-					return err
+				// ボットと判定されていたリアクションは数えていないので減らさない
+				if !existingLike.IsBot {
+					if err := updateLikeCount(tx, -1); err != nil {
+						return err
+					}
 				}
-				response = LikeResponse{IsLiked: false, Message: "Like removed"}
+				reacted, message = false, "Reaction removed"
 			}
 		}
 
@@ -102,16 +141,101 @@ func ToggleLike(c *gin.Context) {
 	})
 
 	if err != nil {
-		log.Printf("ToggleLike database error (article_id=%s, fingerprint=%s): %v", input.ArticleID, input.Fingerprint, err)
+		log.Printf("toggleReaction database error (article_id=%s, fingerprint=%s, reaction=%s): %v", input.ArticleID, input.Fingerprint, reaction, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return article, false, "", false
+	}
+	// ボットのリアクションは数えていないので配信しない
+	if !isBot {
+		publishLikeUpdate(input.ArticleID, article.LikeCount)
+	}
+	return article, reacted, message, true
+}
+
+// publishLikeUpdate はいいね数の更新をカウンターのストリームに配信する。
+// リアクション数は購読している接続がある場合だけ数え直して含める。
+func publishLikeUpdate(articleID string, likeCount int) {
+	var reactions map[string]int64
+	if utils.HasCounterSubscribers(articleID) {
+		counts, err := utils.ArticleReactionCounts(articleID)
+		if err != nil {
+			log.Printf("publishLikeUpdate: Failed to count reactions (article_id=%s): %v", articleID, err)
+		}
+		reactions = counts
+	}
+	utils.PublishLikeCount(articleID, likeCount, reactions)
+}
+
+// いいねを追加または削除
+func ToggleLike(c *gin.Context) {
+	var input LikeRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	article, isLiked, message, ok := toggleReaction(c, input, models.DefaultReaction)
+	if !ok {
 		return
 	}
 
-	response.ArticleID = input.ArticleID
+	c.JSON(http.StatusOK, LikeResponse{
+		ArticleID: input.ArticleID,
+		LikeCount: article.LikeCount,
+		IsLiked:   isLiked,
+		Message:   strings.Replace(message, "Reaction", "Like", 1), // 従来どおり "Like added" などを返す
+	})
+}
+
+// ToggleReaction は種類を指定してリアクションを追加または取り消します
+func ToggleReaction(c *gin.Context) {
+	var input ReactionRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !utils.IsValidReaction(input.Reaction) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reaction", "reactions": utils.ReactionTypes()})
+		return
+	}
+
+	article, reacted, message, ok := toggleReaction(c, input.LikeRequest, input.Reaction)
+	if !ok {
+		return
+	}
+
+	response, err := reactionStatus(input.ArticleID, input.Fingerprint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	response.Reaction = input.Reaction
+	response.Reacted = reacted
 	response.LikeCount = article.LikeCount
+	response.Message = message
 	c.JSON(http.StatusOK, response)
 }
 
+// reactionStatus は記事のリアクション数と、fingerprint が付けているリアクションを返す。
+func reactionStatus(articleID, fingerprint string) (ReactionResponse, error) {
+	counts, err := utils.ArticleReactionCounts(articleID)
+	if err != nil {
+		return ReactionResponse{}, err
+	}
+
+	mine := []string{}
+	if fingerprint != "" {
+		if err := config.DB.Model(&models.Like{}).
+			Where("article_id = ? AND fingerprint = ?", articleID, fingerprint).
+			Pluck("reaction", &mine).Error; err != nil {
+			return ReactionResponse{}, err
+		}
+	}
+	return ReactionResponse{ArticleID: articleID, Reactions: counts, Mine: mine}, nil
+}
+
 // 記事のいいね状態を取得
 func GetLikeStatus(c *gin.Context) {
 	articleID := c.Param("id")
@@ -124,14 +248,14 @@ func GetLikeStatus(c *gin.Context) {
 
 	// 記事の存在確認（Selectで絞り込み）
 	var article models.Article
-	if err := config.DB.Select("id, like_count").Where("id = ?", articleID).First(&article).Error; err != nil {
+	if err := config.DB.Select("id, like_count").Where("id = ? AND status = ?", articleID, models.ArticleStatusPublished).First(&article).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
 		return
 	}
 
 	// いいね状態をチェック
 	var existingLike models.Like
-	isLiked := config.DB.Where("article_id = ? AND fingerprint = ?", articleID, fingerprint).First(&existingLike).Error == nil
+	isLiked := config.DB.Where("article_id = ? AND fingerprint = ? AND reaction = ?", articleID, fingerprint, models.DefaultReaction).First(&existingLike).Error == nil
 
 	c.JSON(http.StatusOK, LikeResponse{
 		ArticleID: articleID,
@@ -140,3 +264,26 @@ func GetLikeStatus(c *gin.Context) {
 		Message:   "Like status retrieved",
 	})
 }
+
+// GetReactionStatus は記事のリアクション数を種類別に返します
+// クエリ fingerprint を指定した場合は、その fingerprint が付けているリアクションも返します
+func GetReactionStatus(c *gin.Context) {
+	var article models.Article
+	if err := config.DB.Select("id, like_count").Where("id = ? AND status = ?", c.Param("id"), models.ArticleStatusPublished).First(&article).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+
+	response, err := reactionStatus(article.ID.String(), c.Query("fingerprint"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	response.LikeCount = article.LikeCount
+	c.JSON(http.StatusOK, response)
+}
+
+// GetReactionTypes は受け付けるリアクションの種類を返します
+func GetReactionTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"reactions": utils.ReactionTypes(), "default": models.DefaultReaction})
+}
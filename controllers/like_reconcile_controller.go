@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"errors"
+	"k-cms/utils"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReconcileLikesInput struct {
+	DryRun    *bool  `json:"dry_run"`    // 省略時は true（修正せずに不一致だけを報告する）
+	ArticleID string `json:"article_id"` // 指定した場合はその記事だけを照合する
+}
+
+// ReconcileLikeCounts は記事の like_count を likes の件数と照合し、不一致を返します
+// dry_run に false を指定した場合のみ不一致を修正し、監査ログに記録します
+func ReconcileLikeCounts(c *gin.Context) {
+	var input ReconcileLikesInput
+	// ボディは省略できる
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	dryRun := input.DryRun == nil || *input.DryRun
+
+	result, err := utils.ReconcileLikeCounts(dryRun, input.ArticleID)
+	if errors.Is(err, utils.ErrReconcileRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reconciliation is already running"})
+		return
+	}
+	if err != nil {
+		log.Printf("いいね数の照合に失敗: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile like counts"})
+		return
+	}
+
+	if !dryRun && result.Fixed > 0 {
+		recordAudit(c, "like_count.reconcile", "article", input.ArticleID, nil, gin.H{
+			"checked":       result.Checked,
+			"mismatched":    result.Mismatched,
+			"fixed":         result.Fixed,
+			"discrepancies": result.Discrepancies,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLoginAttempts はログイン試行履歴をページング付きで返す。
+// クエリ: username, ip, success (true/false), page, per_page
+func GetLoginAttempts(c *gin.Context) {
+	page, perPage, offset := getPagination(c)
+
+	query := config.DB.Model(&models.LoginAttempt{})
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", success == "true")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+		return
+	}
+
+	var attempts []models.LoginAttempt
+	if err := query.Order("created_at desc").Limit(perPage).Offset(offset).Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedResponse(attempts, total, page, perPage))
+}
+
+// GetLockedAccounts は現在ロック中、または失敗回数が残っているアカウントを返す。
+func GetLockedAccounts(c *gin.Context) {
+	type LockedAccount struct {
+		ID               string     `json:"id"`
+		Username         string     `json:"username"`
+		FailedLoginCount int        `json:"failed_login_count"`
+		LockedUntil      *time.Time `json:"locked_until"`
+		Locked           bool       `json:"locked"`
+	}
+
+	var users []models.User
+	if err := config.DB.Where("failed_login_count > 0 OR locked_until > ?", time.Now()).
+		Order("locked_until desc").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locked accounts"})
+		return
+	}
+
+	now := time.Now()
+	accounts := make([]LockedAccount, 0, len(users))
+	for _, u := range users {
+		accounts = append(accounts, LockedAccount{
+			ID:               u.ID.String(),
+			Username:         u.Username,
+			FailedLoginCount: u.FailedLoginCount,
+			LockedUntil:      u.LockedUntil,
+			Locked:           u.LockedUntil != nil && u.LockedUntil.After(now),
+		})
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+// UnlockAccount はアカウントのロックと失敗回数をリセットする。
+func UnlockAccount(c *gin.Context) {
+	id := c.Param("id")
+
+	var user models.User
+	if err := config.DB.Where("id = ?", id).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := config.DB.Model(&user).Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	recordAudit(c, "user.unlock", "user", user.ID.String(),
+		gin.H{"failed_login_count": user.FailedLoginCount, "locked_until": user.LockedUntil},
+		gin.H{"failed_login_count": 0, "locked_until": nil})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"k-cms/config"
+	"k-cms/models"
+	"k-cms/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcStatePurpose = "oidc_state"
+	oidcStateTTL     = 10 * time.Minute
+)
+
+var errOIDCUserNotAllowed = errors.New("no user is linked to this identity")
+
+// oidcClaims は ID トークンから取り出すクレーム
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OIDCLogin は IDプロバイダの認可エンドポイントへリダイレクトする（認可コードフロー + PKCE）。
+// state・nonce・code_verifier は署名付きの短命Cookieに保存し、コールバックで照合する。
+func OIDCLogin(c *gin.Context) {
+	client, err := utils.GetOIDCClient(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, utils.ErrOIDCNotConfigured) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+			return
+		}
+		log.Printf("OIDCプロバイダの取得に失敗: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to contact identity provider"})
+		return
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+		return
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	stateToken, err := utils.SignAuthToken(jwt.MapClaims{
+		"purpose":  oidcStatePurpose,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, stateToken, int(oidcStateTTL.Seconds()), "/api/oidc", "www.katori.dev", true, true)
+
+	authURL := client.OAuth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback は認可コードをトークンに交換し、IDトークンを検証してログインさせる。
+// 通常のログインと同じ auth_token Cookie を発行する。
+func OIDCCallback(c *gin.Context) {
+	client, err := utils.GetOIDCClient(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+
+	if errParam := c.Query("error"); errParam != "" {
+		log.Printf("OIDCログイン失敗 (プロバイダエラー): error=%s description=%s", errParam, c.Query("error_description"))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication was denied by the identity provider"})
+		return
+	}
+
+	// state Cookie の検証（CSRF対策）
+	stateCookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing login state"})
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/api/oidc", "www.katori.dev", true, true)
+
+	stateJWT, err := utils.ParseAuthToken(stateCookie)
+	if err != nil || !stateJWT.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+	stateClaims, _ := stateJWT.Claims.(jwt.MapClaims)
+	if purpose, _ := stateClaims["purpose"].(string); purpose != oidcStatePurpose {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+	expectedState, _ := stateClaims["state"].(string)
+	nonce, _ := stateClaims["nonce"].(string)
+	verifier, _ := stateClaims["verifier"].(string)
+	if expectedState == "" || c.Query("state") != expectedState {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "State mismatch"})
+		return
+	}
+
+	// 認可コードをトークンに交換（PKCE の code_verifier を送る）
+	oauth2Token, err := client.OAuth2.Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("OIDCトークン交換に失敗: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to exchange authorization code"})
+		return
+	}
+
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No id_token in token response"})
+		return
+	}
+	idToken, err := client.Verifier.Verify(c.Request.Context(), rawIDToken)
+	if err != nil {
+		log.Printf("IDトークンの検証に失敗: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid id_token"})
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid id_token claims"})
+		return
+	}
+	if claims.Nonce != nonce {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Nonce mismatch"})
+		return
+	}
+
+	user, err := findOrProvisionOIDCUser(client.Issuer, claims)
+	if err != nil {
+		recordLoginAttempt(c, claims.Email, nil, false, "oidc_denied")
+		if errors.Is(err, errOIDCUserNotAllowed) {
+			log.Printf("OIDCログイン失敗 (紐付くユーザーなし): sub=%s email=%s ip=%s", claims.Subject, claims.Email, c.ClientIP())
+			c.JSON(http.StatusForbidden, gin.H{"error": "No account is linked to this identity"})
+			return
+		}
+		log.Printf("OIDCユーザーの取得に失敗: sub=%s err=%v", claims.Subject, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve user"})
+		return
+	}
+
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		recordLoginAttempt(c, user.Username, &user.ID, false, "locked")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked"})
+		return
+	}
+
+	if err := issueAuthCookie(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	recordLoginAttempt(c, user.Username, &user.ID, true, "oidc")
+
+	c.Redirect(http.StatusFound, config.GetEnv("OIDC_POST_LOGIN_REDIRECT", "https://www.katori.dev/admin"))
+}
+
+// findOrProvisionOIDCUser は IDトークンのクレームから User を特定する。
+// 1. (issuer, sub) で紐付け済みのユーザー
+// 2. 検証済みメールアドレスが一致する既存ユーザー（紐付けを作成）
+// 3. OIDC_JIT_PROVISIONING が有効なら OIDC_DEFAULT_ROLE で新規作成
+func findOrProvisionOIDCUser(issuer string, claims oidcClaims) (models.User, error) {
+	var user models.User
+
+	var identity models.UserIdentity
+	err := config.DB.Where("issuer = ? AND subject = ?", issuer, claims.Subject).First(&identity).Error
+	if err == nil {
+		err = config.DB.Where("id = ?", identity.UserID).First(&user).Error
+		return user, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	// メールアドレスでの紐付けは、プロバイダが検証済みとしたものだけを信頼する
+	emailVerified := claims.EmailVerified != nil && *claims.EmailVerified
+	if claims.Email == "" || (!emailVerified && config.GetEnvBool("OIDC_REQUIRE_VERIFIED_EMAIL", true)) {
+		return user, errOIDCUserNotAllowed
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("email = ?", claims.Email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !config.GetEnvBool("OIDC_JIT_PROVISIONING", false) {
+				return errOIDCUserNotAllowed
+			}
+			role, err := utils.OIDCDefaultRole()
+			if err != nil {
+				return err
+			}
+			username, err := availableUsername(tx, oidcUsername(claims))
+			if err != nil {
+				return err
+			}
+			// パスワードは空にしておき、パスワードでのログインはできないようにする
+			user = models.User{
+				Username: username,
+				Email:    claims.Email,
+				Role:     role,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			log.Printf("OIDCユーザーを自動作成: user_id=%s username=%s role=%s", user.ID, user.Username, user.Role)
+		} else if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:  user.ID,
+			Issuer:  issuer,
+			Subject: claims.Subject,
+			Email:   claims.Email,
+		}).Error
+	})
+	return user, err
+}
+
+// oidcUsername はクレームからユーザー名の候補を決める。
+func oidcUsername(claims oidcClaims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+	if local, _, ok := strings.Cut(claims.Email, "@"); ok && local != "" {
+		return local
+	}
+	return "user"
+}
+
+// availableUsername は重複しないユーザー名を返す（重複時はランダムな接尾辞を付ける）。
+func availableUsername(tx *gorm.DB, base string) (string, error) {
+	candidate := base
+	for i := 0; i < 5; i++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		suffix, err := randomHex(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + suffix
+	}
+	return "", errors.New("failed to find an available username")
+}
@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"k-cms/config"
+	"k-cms/models"
+	"k-cms/utils"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testOIDCClientID     = "k-cms-test"
+	testOIDCClientSecret = "test-secret"
+	testOIDCRedirectURL  = "https://www.katori.dev/api/oidc/callback"
+	testOIDCKid          = "test-key"
+)
+
+// mockOIDCProvider は ディスカバリ・JWKS・トークンエンドポイントを提供するテスト用の IDプロバイダ
+type mockOIDCProvider struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+// mockAuthorization は発行した認可コードに紐付く PKCE の code_challenge・nonce・IDトークンのクレーム
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	p := &mockOIDCProvider{t: t, key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeTestJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.srv.URL,
+		"authorization_endpoint":                p.srv.URL + "/authorize",
+		"token_endpoint":                        p.srv.URL + "/token",
+		"jwks_uri":                              p.srv.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeTestJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testOIDCKid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// token は認可コードを IDトークンに交換する。code_verifier が code_challenge と一致しない場合は invalid_grant を返す。
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+	if clientID != testOIDCClientID || clientSecret != testOIDCClientSecret {
+		writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	auth, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code")) // 認可コードは1回限り
+	p.mu.Unlock()
+	if !ok {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.srv.URL,
+		"aud":   testOIDCClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": auth.nonce,
+	}
+	for k, v := range auth.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testOIDCKid
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		p.t.Errorf("sign id_token: %v", err)
+		writeTestJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeTestJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "test-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// authorize は認可エンドポイントでのログインを模擬し、claims のユーザーとして認可コードを発行する。
+func (p *mockOIDCProvider) authorize(authURL string, claims jwt.MapClaims) (code, state string) {
+	p.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatalf("parse authorization url: %v", err)
+	}
+	q := u.Query()
+	if got := u.Scheme + "://" + u.Host + u.Path; got != p.srv.URL+"/authorize" {
+		p.t.Fatalf("redirected to %s, want authorization endpoint", got)
+	}
+	if q.Get("response_type") != "code" || q.Get("client_id") != testOIDCClientID || q.Get("redirect_uri") != testOIDCRedirectURL {
+		p.t.Fatalf("unexpected authorization request: %s", u.RawQuery)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		p.t.Fatalf("authorization request has no PKCE challenge: %s", u.RawQuery)
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" {
+		p.t.Fatalf("authorization request has no state or nonce: %s", u.RawQuery)
+	}
+
+	code, err = randomHex(16)
+	if err != nil {
+		p.t.Fatalf("generate code: %v", err)
+	}
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	p.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// setupOIDCTest はテスト用の DB・JWT の鍵・モックの IDプロバイダを用意し、OIDC のルートを持つルーターを返す。
+// OIDC クライアントはプロセス内でキャッシュされるため、プロバイダはこのテストで1つだけ起動する。
+func setupOIDCTest(t *testing.T) (*gin.Engine, *mockOIDCProvider) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.UserIdentity{}, &models.LoginAttempt{}, &models.JWTKey{}); err != nil {
+		t.Fatalf("migrate test db: %v", err)
+	}
+	prevDB := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = prevDB })
+
+	t.Setenv("JWT_SECRET", "oidc-test-jwt-secret")
+	if err := utils.RegisterLegacyJWTKey(); err != nil {
+		t.Fatalf("register jwt key: %v", err)
+	}
+
+	provider := newMockOIDCProvider(t)
+	t.Setenv("OIDC_ISSUER_URL", provider.srv.URL)
+	t.Setenv("OIDC_CLIENT_ID", testOIDCClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", testOIDCClientSecret)
+	t.Setenv("OIDC_REDIRECT_URL", testOIDCRedirectURL)
+	t.Setenv("OIDC_POST_LOGIN_REDIRECT", "https://www.katori.dev/admin")
+
+	r := gin.New()
+	r.GET("/api/oidc/login", OIDCLogin)
+	r.GET("/api/oidc/callback", OIDCCallback)
+	return r, provider
+}
+
+// startOIDCLogin は /api/oidc/login を呼び、認可エンドポイントの URL と state Cookie を返す。
+func startOIDCLogin(t *testing.T, r *gin.Engine) (authURL string, stateCookie *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status = %d, want 302 (body=%s)", w.Code, w.Body.String())
+	}
+	stateCookie = findCookie(w.Result().Cookies(), oidcStateCookie)
+	if stateCookie == nil || stateCookie.Value == "" {
+		t.Fatalf("login: %s cookie is not set", oidcStateCookie)
+	}
+	return w.Header().Get("Location"), stateCookie
+}
+
+// finishOIDCLogin は IDプロバイダからのリダイレクトを模擬して /api/oidc/callback を呼ぶ。
+func finishOIDCLogin(r *gin.Engine, code, state string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	q := url.Values{"code": {code}, "state": {state}}
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?"+q.Encode(), nil)
+	if stateCookie != nil {
+		req.AddCookie(&http.Cookie{Name: stateCookie.Name, Value: stateCookie.Value})
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// loginAs はログインの開始から callback までを行う。
+func loginAs(t *testing.T, r *gin.Engine, p *mockOIDCProvider, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t.Helper()
+	authURL, stateCookie := startOIDCLogin(t, r)
+	code, state := p.authorize(authURL, claims)
+	return finishOIDCLogin(r, code, state, stateCookie)
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// assertLoggedInAs は auth_token Cookie が発行され、user のトークンであることを確認する。
+func assertLoggedInAs(t *testing.T, w *httptest.ResponseRecorder, user models.User) {
+	t.Helper()
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback: status = %d, want 302 (body=%s)", w.Code, w.Body.String())
+	}
+	if loc := w.Header().Get("Location"); loc != "https://www.katori.dev/admin" {
+		t.Errorf("callback: redirected to %q", loc)
+	}
+	cookie := findCookie(w.Result().Cookies(), "auth_token")
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("callback: auth_token cookie is not set")
+	}
+	if !cookie.HttpOnly || !cookie.Secure {
+		t.Errorf("auth_token cookie must be HttpOnly and Secure (HttpOnly=%v Secure=%v)", cookie.HttpOnly, cookie.Secure)
+	}
+	token, err := utils.ParseAuthToken(cookie.Value)
+	if err != nil || !token.Valid {
+		t.Fatalf("auth_token is not valid: %v", err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if got, _ := claims["user_id"].(string); got != user.ID.String() {
+		t.Errorf("auth_token user_id = %q, want %q", got, user.ID)
+	}
+}
+
+func assertNotLoggedIn(t *testing.T, w *httptest.ResponseRecorder, wantStatus int) {
+	t.Helper()
+	if w.Code != wantStatus {
+		t.Fatalf("callback: status = %d, want %d (body=%s)", w.Code, wantStatus, w.Body.String())
+	}
+	if cookie := findCookie(w.Result().Cookies(), "auth_token"); cookie != nil {
+		t.Error("callback: auth_token cookie must not be set")
+	}
+}
+
+func countRows(t *testing.T, model interface{}, query string, args ...interface{}) int64 {
+	t.Helper()
+	var count int64
+	if err := config.DB.Model(model).Where(query, args...).Count(&count).Error; err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return count
+}
+
+func TestOIDCLogin(t *testing.T) {
+	r, provider := setupOIDCTest(t)
+	issuer := provider.srv.URL
+
+	t.Run("JIT無効の場合は未登録のユーザーを拒否する", func(t *testing.T) {
+		t.Setenv("OIDC_JIT_PROVISIONING", "false")
+		w := loginAs(t, r, provider, jwt.MapClaims{"sub": "sub-unknown", "email": "unknown@example.com", "email_verified": true})
+
+		assertNotLoggedIn(t, w, http.StatusForbidden)
+		if n := countRows(t, &models.User{}, "email = ?", "unknown@example.com"); n != 0 {
+			t.Errorf("user was created with JIT disabled")
+		}
+		if n := countRows(t, &models.LoginAttempt{}, "username = ? AND reason = ?", "unknown@example.com", "oidc_denied"); n != 1 {
+			t.Errorf("denied login attempts = %d, want 1", n)
+		}
+	})
+
+	t.Run("JIT有効の場合は OIDC_DEFAULT_ROLE でユーザーを作成する", func(t *testing.T) {
+		t.Setenv("OIDC_JIT_PROVISIONING", "true")
+		w := loginAs(t, r, provider, jwt.MapClaims{
+			"sub": "sub-new", "email": "new@example.com", "email_verified": true, "preferred_username": "newbie",
+		})
+
+		var user models.User
+		if err := config.DB.Where("email = ?", "new@example.com").First(&user).Error; err != nil {
+			t.Fatalf("user was not provisioned: %v", err)
+		}
+		if user.Username != "newbie" || user.Role != models.RoleEditor {
+			t.Errorf("provisioned user = {username:%q role:%q}, want {newbie editor}", user.Username, user.Role)
+		}
+		if n := countRows(t, &models.UserIdentity{}, "issuer = ? AND subject = ? AND user_id = ?", issuer, "sub-new", user.ID); n != 1 {
+			t.Errorf("identity for (issuer, sub-new) = %d, want 1", n)
+		}
+		assertLoggedInAs(t, w, user)
+	})
+
+	t.Run("検証済みメールアドレスで既存ユーザーに紐付ける", func(t *testing.T) {
+		t.Setenv("OIDC_JIT_PROVISIONING", "false")
+		existing := models.User{Username: "existing", Email: "existing@example.com", Role: models.RoleAdmin}
+		if err := config.DB.Create(&existing).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+
+		w := loginAs(t, r, provider, jwt.MapClaims{"sub": "sub-existing", "email": "existing@example.com", "email_verified": true})
+
+		assertLoggedInAs(t, w, existing)
+		if n := countRows(t, &models.UserIdentity{}, "issuer = ? AND subject = ? AND user_id = ?", issuer, "sub-existing", existing.ID); n != 1 {
+			t.Errorf("identity for (issuer, sub-existing) = %d, want 1", n)
+		}
+		if n := countRows(t, &models.User{}, "email = ?", "existing@example.com"); n != 1 {
+			t.Errorf("users with existing@example.com = %d, want 1", n)
+		}
+	})
+
+	t.Run("紐付け済みの subject はメールアドレスが変わっても同じユーザーになる", func(t *testing.T) {
+		t.Setenv("OIDC_JIT_PROVISIONING", "true")
+		var existing models.User
+		if err := config.DB.Where("email = ?", "existing@example.com").First(&existing).Error; err != nil {
+			t.Fatalf("find user: %v", err)
+		}
+
+		w := loginAs(t, r, provider, jwt.MapClaims{"sub": "sub-existing", "email": "renamed@example.com", "email_verified": true})
+
+		assertLoggedInAs(t, w, existing)
+		if n := countRows(t, &models.User{}, "email = ?", "renamed@example.com"); n != 0 {
+			t.Errorf("a new user was created for a linked subject")
+		}
+	})
+
+	t.Run("未検証のメールアドレスでは紐付けない", func(t *testing.T) {
+		t.Setenv("OIDC_JIT_PROVISIONING", "true")
+		w := loginAs(t, r, provider, jwt.MapClaims{"sub": "sub-unverified", "email": "existing@example.com", "email_verified": false})
+
+		assertNotLoggedIn(t, w, http.StatusForbidden)
+		if n := countRows(t, &models.UserIdentity{}, "subject = ?", "sub-unverified"); n != 0 {
+			t.Errorf("identity was linked with an unverified email")
+		}
+	})
+
+	t.Run("state が一致しない場合は拒否する", func(t *testing.T) {
+		authURL, stateCookie := startOIDCLogin(t, r)
+		code, _ := provider.authorize(authURL, jwt.MapClaims{"sub": "sub-new", "email": "new@example.com", "email_verified": true})
+
+		assertNotLoggedIn(t, finishOIDCLogin(r, code, "forged-state", stateCookie), http.StatusBadRequest)
+	})
+
+	t.Run("state Cookie が無い場合は拒否する", func(t *testing.T) {
+		authURL, _ := startOIDCLogin(t, r)
+		code, state := provider.authorize(authURL, jwt.MapClaims{"sub": "sub-new", "email": "new@example.com", "email_verified": true})
+
+		assertNotLoggedIn(t, finishOIDCLogin(r, code, state, nil), http.StatusBadRequest)
+	})
+
+	t.Run("別のログインの code_verifier では認可コードを交換できない", func(t *testing.T) {
+		// 攻撃者のログインで得た認可コードを、被害者のブラウザの state で使わせる（PKCE で防ぐ）
+		victimURL, victimCookie := startOIDCLogin(t, r)
+		_, victimState := provider.authorize(victimURL, jwt.MapClaims{"sub": "sub-victim"})
+		attackerURL, _ := startOIDCLogin(t, r)
+		attackerCode, _ := provider.authorize(attackerURL, jwt.MapClaims{"sub": "sub-new", "email": "new@example.com", "email_verified": true})
+
+		assertNotLoggedIn(t, finishOIDCLogin(r, attackerCode, victimState, victimCookie), http.StatusUnauthorized)
+	})
+}
@@ -4,13 +4,12 @@ import (
 	"errors"
 	"k-cms/config"
 	"k-cms/models"
+	"k-cms/utils"
 	"net/http"
 	"time"
 
 	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/gin-gonic/gin"
-	"github.com/gofrs/uuid/v5"
-	"gorm.io/gorm"
 )
 
 // isDuplicateKeyError は MySQL の Duplicate entry エラー（1062）かどうかを判定する。
@@ -25,6 +24,15 @@ func isDuplicateKeyError(err error) bool {
 type PageViewRequest struct {
 	ArticleID   string `json:"article_id" binding:"required"`
 	Fingerprint string `json:"fingerprint" binding:"required"`
+
+	// 流入元の情報（いずれも任意）
+	Referrer    string `json:"referrer"`     // document.referrer
+	PageURL     string `json:"page_url"`     // 閲覧中のページの URL（utm_* クエリを含む）
+	UTMSource   string `json:"utm_source"`   // 指定された場合は page_url のクエリより優先する
+	UTMMedium   string `json:"utm_medium"`
+	UTMCampaign string `json:"utm_campaign"`
+	Language    string `json:"language"` // navigator.language（省略時は Accept-Language）
+	Webdriver   bool   `json:"webdriver"` // navigator.webdriver（自動操作されたブラウザの判定に使う）
 }
 
 type PageViewResponse struct {
@@ -34,7 +42,8 @@ type PageViewResponse struct {
 }
 
 // RecordPageView は1記事・1fingerprint・1日で1回だけカウントを記録する。
-// 既存レコードがある場合は何もせず現在のカウントを返す（冪等）。
+// PV はメモリ上で重複排除して書き出し待ちに溜め、一定間隔でまとめて保存する（utils.BufferPageView）。
+// 既に受け付けている場合は何もせず現在のカウントを返す（冪等）。
 func RecordPageView(c *gin.Context) {
 	var input PageViewRequest
 
@@ -49,51 +58,38 @@ func RecordPageView(c *gin.Context) {
 		return
 	}
 
-	// 記事の存在確認
+	// 公開中の記事かを確認（下書き・非公開の記事の PV は記録しない）
 	var article models.Article
-	if err := config.DB.Where("id = ?", input.ArticleID).First(&article).Error; err != nil {
+	if err := config.DB.Select("id").Where("id = ? AND status = ?", input.ArticleID, models.ArticleStatusPublished).First(&article).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
 		return
 	}
 
-	// 今日の日付をローカルタイムゾーン基準の DATE 文字列で取得する。
-	// time.Truncate(24h) はUTC基準のため JST(UTC+9) では MySQL の DATE 型との比較がずれる。
-	todayStr := time.Now().Local().Format("2006-01-02")
-	clientIP := c.ClientIP()
-
-	// 同一記事・同一fingerprint・同一日のレコードを検索
-	var existing models.PageView
-	err := config.DB.Where(
-		"article_id = ? AND fingerprint = ? AND visited_date = ?",
-		input.ArticleID, input.Fingerprint, todayStr,
-	).First(&existing).Error
-
-	if err == gorm.ErrRecordNotFound {
-		// 初回アクセス → 記録する
-		articleUUID, _ := uuid.FromString(input.ArticleID)
-		newView := models.PageView{
-			ArticleID:   articleUUID,
-			Fingerprint: input.Fingerprint,
-			IPAddress:   clientIP,
-			VisitedDate: time.Now().Local(),
-		}
-		if createErr := config.DB.Create(&newView).Error; createErr != nil {
-			// UNIQUE制約違反（タイムゾーンズレや競合リクエスト等）はスキップ扱いとし
-			// カウントを返す。それ以外のDBエラーは 500 を返す。
-			if !isDuplicateKeyError(createErr) {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record page view"})
-				return
-			}
-		}
-	} else if err != nil {
+	// ボットらしいアクセスも記録はするが、カウントには含めない
+	botReason := utils.DetectBot(c.Request, input.Fingerprint, input.Webdriver)
+
+	// visited_date はローカルタイムゾーン基準の日付として保存される（MySQL の DATE 型）。
+	// time.Truncate(24h) はUTC基準のため JST(UTC+9) では日付がずれるので使わない。
+	view := models.PageView{
+		ArticleID:   article.ID,
+		Fingerprint: input.Fingerprint,
+		IPAddress:   utils.AnonymizeIP(c.ClientIP()), // PRIVACY_IP_MODE に従ってハッシュ化・切り詰めする
+		VisitedDate: time.Now().Local(),
+	}
+	setPageViewAttributes(c, &view, &input)
+	view.IsBot = botReason != ""
+	view.BotReason = botReason
+	counted := utils.BufferPageView(view)
+
+	// 総PV数を返す（保存済みの件数はキャッシュし、書き出し待ちの件数を加える）
+	count, err := utils.CachedViewCount(input.ArticleID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	// else: 既存レコードあり → スキップ（冪等）
-
-	// 総PV数を返す（INSERT成功・スキップ・既存どのケースでも必ずここに到達する）
-	var count int64
-	config.DB.Model(&models.PageView{}).Where("article_id = ?", input.ArticleID).Count(&count)
+	if counted {
+		utils.PublishViewCount(input.ArticleID, count)
+	}
 
 	c.JSON(http.StatusOK, PageViewResponse{
 		ArticleID: input.ArticleID,
@@ -102,19 +98,41 @@ func RecordPageView(c *gin.Context) {
 	})
 }
 
-// GetPageViewCount は記事の総PV数を返す。
+// setPageViewAttributes はリクエストから流入元（参照元・UTM）と閲覧環境（言語・端末・ブラウザ）を設定する。
+func setPageViewAttributes(c *gin.Context, view *models.PageView, input *PageViewRequest) {
+	view.ReferrerHost, view.Source = utils.ClassifyReferrer(input.Referrer)
+	view.ReferrerHost = truncate(view.ReferrerHost, 255)
+
+	utmSource, utmMedium, utmCampaign := utils.ParseUTM(input.PageURL)
+	if input.UTMSource != "" || input.UTMMedium != "" || input.UTMCampaign != "" {
+		utmSource, utmMedium, utmCampaign = input.UTMSource, input.UTMMedium, input.UTMCampaign
+	}
+	view.UTMSource = truncate(utmSource, 100)
+	view.UTMMedium = truncate(utmMedium, 100)
+	view.UTMCampaign = truncate(utmCampaign, 100)
+
+	language := input.Language
+	if language == "" {
+		language = c.GetHeader("Accept-Language")
+	}
+	view.Language = utils.PrimaryLanguage(language)
+
+	view.DeviceClass, view.Browser = utils.ParseUserAgent(c.Request.UserAgent())
+	view.UserAgent = truncate(c.Request.UserAgent(), 255)
+}
+
+// GetPageViewCount は記事の総PV数を返す（日次集計 + 当日分）。
 func GetPageViewCount(c *gin.Context) {
 	articleID := c.Param("id")
 
-	// 記事の存在確認
+	// 公開中の記事かを確認
 	var article models.Article
-	if err := config.DB.Where("id = ?", articleID).First(&article).Error; err != nil {
+	if err := config.DB.Select("id").Where("id = ? AND status = ?", articleID, models.ArticleStatusPublished).First(&article).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
 		return
 	}
 
-	var count int64
-	config.DB.Model(&models.PageView{}).Where("article_id = ?", articleID).Count(&count)
+	count, _ := utils.CachedViewCount(articleID)
 
 	c.JSON(http.StatusOK, PageViewResponse{
 		ArticleID: articleID,
@@ -122,3 +140,8 @@ func GetPageViewCount(c *gin.Context) {
 		Message:   "OK",
 	})
 }
+
+// GetPageViewBufferStats は PV の書き出し待ちの件数（キューの深さ）と、受け付け・書き出しの累計を返します
+func GetPageViewBufferStats(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.GetPageViewBufferStats())
+}
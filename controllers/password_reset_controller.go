@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"k-cms/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordInput struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"` // 長さなどは ValidatePasswordPolicy で検証する
+}
+
+// errPasswordResetCooldown は直前に発行した未使用のリンクがあるため、再発行しないことを表す
+var errPasswordResetCooldown = errors.New("password reset link was issued recently")
+
+// hashResetToken はトークンの SHA-256 ハッシュを16進文字列で返す。
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ForgotPassword はパスワード再設定用のワンタイムリンクをメールで送信する。
+// メールアドレスの登録有無に関わらず同じレスポンスを返す。
+func ForgotPassword(c *gin.Context) {
+	var input ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "If the email is registered, a password reset link has been sent"}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
+		log.Printf("パスワード再設定リクエスト (未登録メールアドレス): ip=%s", c.ClientIP())
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// トークンの保存とメール送信は応答後に行い、登録済みの場合も未登録と同じ速さで応答する
+	// （応答時間から登録有無を推測されないようにする）
+	go sendPasswordResetLink(user, c.ClientIP())
+
+	c.JSON(http.StatusOK, response)
+}
+
+// sendPasswordResetLink はワンタイムトークンを発行し、再設定用のリンクをメールで送信する。
+// PASSWORD_RESET_COOLDOWN（デフォルト5分）以内に発行した未使用のリンクがあれば何もしない
+// （メールの大量送信や、正規のリンクを第三者のリクエストで無効化されるのを防ぐ）。
+// 応答後に実行されるため、失敗はログに記録するのみ。
+func sendPasswordResetLink(user models.User, clientIP string) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("パスワード再設定トークンの生成に失敗: user_id=%s err=%v", user.ID, err)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	ttl := config.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+
+	cooldown := config.GetEnvDuration("PASSWORD_RESET_COOLDOWN", 5*time.Minute)
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var recent int64
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ? AND created_at > ?", user.ID, time.Now(), time.Now().Add(-cooldown)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			return errPasswordResetCooldown
+		}
+
+		// 未使用の古いトークンは無効化し、有効なリンクは常に最新の1つだけにする
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hashResetToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+			RequestIP: clientIP,
+		}).Error
+	})
+	if errors.Is(err, errPasswordResetCooldown) {
+		log.Printf("パスワード再設定リクエストを無視 (%v 以内に発行済み): user_id=%s ip=%s", cooldown, user.ID, clientIP)
+		return
+	}
+	if err != nil {
+		log.Printf("パスワード再設定トークンの保存に失敗: user_id=%s err=%v", user.ID, err)
+		return
+	}
+
+	resetURL := config.GetEnv("PASSWORD_RESET_URL", "https://www.katori.dev/admin/reset-password") + "?token=" + url.QueryEscape(token)
+	msg := utils.MailMessage{
+		To:      []string{user.Email},
+		Subject: "パスワード再設定のご案内",
+		Body: fmt.Sprintf(
+			"%s さん\n\n"+
+				"パスワード再設定のリクエストを受け付けました。\n"+
+				"以下のリンクから %d 分以内に新しいパスワードを設定してください。\n\n"+
+				"%s\n\n"+
+				"このリクエストに心当たりがない場合は、このメールを破棄してください。\n",
+			user.Username, int(ttl.Minutes()), resetURL,
+		),
+	}
+	if err := utils.GetMailer().Send(msg); err != nil {
+		log.Printf("パスワード再設定メールの送信に失敗: user_id=%s err=%v", user.ID, err)
+		return
+	}
+	log.Printf("パスワード再設定リンクを発行: user_id=%s ip=%s", user.ID, clientIP)
+}
+
+// ResetPassword はワンタイムトークンを検証してパスワードを再設定する。
+// 再設定後は既存のログイン（発行済みJWT）をすべて無効化する。
+func ResetPassword(c *gin.Context) {
+	var input ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := utils.ValidatePasswordPolicy(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(input.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードのハッシュ化に失敗しました"})
+		return
+	}
+
+	errInvalidToken := fmt.Errorf("invalid or expired token")
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// 使用済みマークを先に付けることで、同じトークンの同時使用を防ぐ
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashResetToken(input.Token), now).
+			Update("used_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidToken
+		}
+
+		var resetToken models.PasswordResetToken
+		if err := tx.Where("token_hash = ?", hashResetToken(input.Token)).First(&resetToken).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Updates(map[string]interface{}{
+			"password":           hashedPassword,
+			"token_version":      gorm.Expr("token_version + ?", 1),
+			"failed_login_count": 0,
+			"locked_until":       nil,
+		}).Error
+	})
+
+	if err == errInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err != nil {
+		log.Printf("パスワード再設定に失敗: err=%v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの更新に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードが再設定されました。新しいパスワードでログインしてください"})
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"errors"
+	"k-cms/config"
+	"k-cms/middlewares"
+	"k-cms/models"
+	"k-cms/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+type PreviewTokenInput struct {
+	ExpiresInHours int    `json:"expires_in_hours" binding:"omitempty,min=1"` // 省略時は PREVIEW_TOKEN_TTL
+	Note           string `json:"note" binding:"max=255"`
+	Build          bool   `json:"build"` // true ならプレビュー用のビルド（events に preview を指定したターゲット）を実行する
+}
+
+type PreviewResponse struct {
+	ArticleResponse
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"preview_expires_at"`
+}
+
+// findManageableArticle は記事を取得し、ログインユーザーが記事の作成者か admin であることを確認します
+// 失敗した場合はレスポンスを書き込んで nil を返します
+func findManageableArticle(c *gin.Context, id string) *models.Article {
+	var article models.Article
+	if err := config.DB.Where("id = ?", id).First(&article).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return nil
+	}
+
+	user, err := middlewares.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil
+	}
+	if user.ID != article.UserID && user.Role != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to share this article"})
+		return nil
+	}
+	return &article
+}
+
+// CreatePreviewToken は記事のプレビューリンクを発行します
+// トークンはこのレスポンスでのみ返すため、紛失した場合は発行し直してください
+func CreatePreviewToken(c *gin.Context) {
+	var input PreviewTokenInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	article := findManageableArticle(c, c.Param("id"))
+	if article == nil {
+		return
+	}
+	userUUID, _ := middlewares.GetUserIDFromContext(c)
+
+	record, token, err := utils.IssuePreviewToken(article.ID, userUUID, time.Duration(input.ExpiresInHours)*time.Hour, input.Note)
+	if err != nil {
+		log.Printf("プレビュートークンの発行に失敗: article=%s err=%v", article.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create preview token"})
+		return
+	}
+
+	recordAudit(c, "article.preview_token.create", "article_preview_token", record.ID.String(), nil, record)
+
+	response := gin.H{
+		"preview_token": record,
+		"token":         token,
+		"url":           utils.PreviewURL(token),
+	}
+	if input.Build {
+		job, err := utils.TriggerBuild(utils.BuildRequest{Source: "preview", Action: utils.BuildActionPreview, ArticleID: article.ID.String(), UserID: &userUUID, PreviewToken: token})
+		if err == nil {
+			response["build_id"] = job.ID
+		} else if !errors.Is(err, utils.ErrBuildNotConfigured) {
+			log.Printf("プレビュー用ビルドの登録に失敗: article=%s err=%v", article.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetPreviewTokens は記事のプレビューリンクの発行履歴を新しい順に返します（トークン本体は含まない）
+func GetPreviewTokens(c *gin.Context) {
+	article := findManageableArticle(c, c.Param("id"))
+	if article == nil {
+		return
+	}
+
+	var tokens []models.ArticlePreviewToken
+	if err := config.DB.Where("article_id = ?", article.ID).Order("created_at desc").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch preview tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokePreviewToken はプレビューリンクを失効させます
+func RevokePreviewToken(c *gin.Context) {
+	var record models.ArticlePreviewToken
+	if err := config.DB.Where("id = ?", c.Param("id")).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Preview token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch preview token"})
+		return
+	}
+	if findManageableArticle(c, record.ArticleID.String()) == nil {
+		return
+	}
+
+	var revokedBy *uuid.UUID
+	if id, err := middlewares.GetUserIDFromContext(c); err == nil {
+		revokedBy = &id
+	}
+	before := record
+	if err := utils.RevokePreviewToken(&record, revokedBy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke preview token"})
+		return
+	}
+
+	if before.RevokedAt == nil {
+		recordAudit(c, "article.preview_token.revoke", "article_preview_token", record.ID.String(), before, record)
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+// GetPreview はプレビュートークンで記事の現在の内容（下書きを含む）を返します
+// アカウントを持たないレビュー担当者が閲覧するためのエンドポイントで、検索エンジンにはインデックスさせない
+func GetPreview(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex, nofollow")
+
+	record, err := utils.ResolvePreviewToken(c.Param("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrPreviewTokenRevoked):
+			c.JSON(http.StatusGone, gin.H{"error": "Preview link has been revoked"})
+		case errors.Is(err, utils.ErrPreviewTokenInvalid):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Preview link is invalid or expired"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify preview link"})
+		}
+		return
+	}
+
+	var article models.Article
+	if err := config.DB.Where("id = ?", record.ArticleID).First(&article).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, PreviewResponse{
+		ArticleResponse: ArticleResponse{
+			ID:            article.ID.String(),
+			Title:         article.Title,
+			Excerpt:       article.Excerpt,
+			CoverImageURL: article.CoverImageURL,
+			OgImageURL:    article.OgImageURL,
+			Tags:          article.Tags,
+			Datetime:      article.Datetime,
+			Content:       article.Content,
+			LikeCount:     article.LikeCount,
+		},
+		Status:    article.Status,
+		ExpiresAt: record.ExpiresAt,
+	})
+}
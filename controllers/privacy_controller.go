@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"errors"
+	"k-cms/utils"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EraseFingerprintInput struct {
+	Fingerprint string `json:"fingerprint" binding:"required"`
+}
+
+// EraseFingerprintData は読者からの依頼に応じて、fingerprint に紐づくページビュー・いいね・コメントを全て削除します
+// 監査ログには fingerprint そのものではなくダイジェストを記録します
+func EraseFingerprintData(c *gin.Context) {
+	var input EraseFingerprintInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := utils.EraseFingerprintData(input.Fingerprint)
+	if errors.Is(err, utils.ErrInvalidFingerprint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fingerprint"})
+		return
+	}
+	if err != nil {
+		log.Printf("fingerprint のデータ削除に失敗: digest=%s err=%v", utils.FingerprintDigest(input.Fingerprint), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase data"})
+		return
+	}
+
+	recordAudit(c, "privacy.erase", "fingerprint", utils.FingerprintDigest(input.Fingerprint), nil, result)
+	c.JSON(http.StatusOK, gin.H{"message": "Data erased", "erased": result})
+}
@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"k-cms/config"
+	"k-cms/middlewares"
 	"k-cms/models"
 	"k-cms/utils"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,6 +35,7 @@ func UpdateSiteConfig(c *gin.Context) {
 	}
 
 	var siteConfig models.SiteConfig
+	var before *models.SiteConfig
 	if err := config.DB.First(&siteConfig).Error; err != nil {
 		// レコードがない場合は新規作成として扱う
 		siteConfig = input
@@ -41,6 +44,9 @@ func UpdateSiteConfig(c *gin.Context) {
 			return
 		}
 	} else {
+		previous := siteConfig
+		before = &previous
+
 		// 既存レコードの更新
 		// IDは上書きしないよう注意（Firstで取得しているのでIDはセットされているはず）
 		// GORMのUpdatesはゼロ値を無視するので、Model(&siteConfig).Updates(input)だとboolのfalse等が更新されない可能性があるが
@@ -65,8 +71,14 @@ func UpdateSiteConfig(c *gin.Context) {
 		}
 	}
 
+	recordAudit(c, "site_config.update", "site_config", strconv.FormatUint(uint64(siteConfig.ID), 10), before, siteConfig)
+
 	// 設定変更後はビルドをトリガー
-	go utils.TriggerBuild("update_site_config", "")
+	buildReq := utils.BuildRequest{Source: "site_config", Action: "update_site_config"}
+	if userID, err := middlewares.GetUserIDFromContext(c); err == nil {
+		buildReq.UserID = &userID
+	}
+	utils.TriggerBuild(buildReq)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Site config updated and build triggered", "data": siteConfig})
 }
@@ -4,10 +4,10 @@ import (
 	"k-cms/config"
 	"k-cms/middlewares"
 	"k-cms/models"
+	"k-cms/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func GetUsers(c *gin.Context) {
@@ -211,6 +211,8 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "user.delete", "user", user.ID.String(), user, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 }
 
@@ -237,23 +239,32 @@ func ChangePassword(c *gin.Context) {
 	}
 
 	// 現在のパスワードを検証
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+	if ok, _, _ := utils.VerifyPassword(user.Password, input.CurrentPassword); !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "現在のパスワードが正しくありません"})
 		return
 	}
 
+	// パスワードポリシー（長さ・漏洩パスワード）のチェック
+	if err := utils.ValidatePasswordPolicy(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 新しいパスワードをハッシュ化
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), 12)
+	hashedPassword, err := utils.HashPassword(input.NewPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードのハッシュ化に失敗しました"})
 		return
 	}
 
 	// パスワードを更新
-	if err := config.DB.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+	if err := config.DB.Model(&user).Update("password", hashedPassword).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの更新に失敗しました"})
 		return
 	}
 
+	// パスワードそのものは記録しない
+	recordAudit(c, "user.change_password", "user", user.ID.String(), nil, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "パスワードが正常に変更されました"})
 }
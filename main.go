@@ -1,19 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"k-cms/config"
 	"k-cms/models"
 	"k-cms/routes"
+	"k-cms/utils"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	if os.Getenv("JWT_SECRET") == "" {
-		panic("JWT_SECRET environment variable is not set. Please set it for security.")
-	}
-
 	config.ConnectDB()
 
 	if err := config.DB.AutoMigrate(&models.User{}); err != nil {
@@ -32,16 +36,118 @@ func main() {
 		panic("Failed to migrate database.")
 	}
 
+	if err := models.MigrateArticlePreviewToken(config.DB); err != nil {
+		panic("Failed to migrate article_preview_token table.")
+	}
+
 	if err := models.MigrateLike(config.DB); err != nil {
 		panic("Failed to migrate like table.")
 	}
 
+	if err := models.MigrateComment(config.DB); err != nil {
+		panic("Failed to migrate comment table.")
+	}
+
 	if err := models.MigratePageView(config.DB); err != nil {
 		panic("Failed to migrate page_view table.")
 	}
 
+	if err := models.MigrateArticleDailyStat(config.DB); err != nil {
+		panic("Failed to migrate article_daily_stat table.")
+	}
+
+	if err := models.MigratePrivacySalt(config.DB); err != nil {
+		panic("Failed to migrate privacy_salt table.")
+	}
+
+	if err := models.MigrateJWTKey(config.DB); err != nil {
+		panic("Failed to migrate jwt_key table.")
+	}
+
+	if err := models.MigrateLoginAttempt(config.DB); err != nil {
+		panic("Failed to migrate login_attempt table.")
+	}
+
+	if err := models.MigratePasswordResetToken(config.DB); err != nil {
+		panic("Failed to migrate password_reset_token table.")
+	}
+
+	if err := models.MigrateUserIdentity(config.DB); err != nil {
+		panic("Failed to migrate user_identity table.")
+	}
+
+	if err := models.MigrateAuditEvent(config.DB); err != nil {
+		panic("Failed to migrate audit_event table.")
+	}
+
+	if err := models.MigrateBuild(config.DB); err != nil {
+		panic("Failed to migrate build table.")
+	}
+
+	if err := models.MigrateDeploy(config.DB); err != nil {
+		panic("Failed to migrate deploy table.")
+	}
+
+	if err := utils.CheckPasswordHashSettings(); err != nil {
+		panic("Invalid password hash settings: " + err.Error())
+	}
+	if utils.OIDCEnabled() {
+		if _, err := utils.OIDCDefaultRole(); err != nil {
+			panic("Invalid OIDC settings: " + err.Error())
+		}
+	}
+
+	// ローテーション導入前の JWT_SECRET を legacy の鍵として鍵セットに登録する（退役させるまで kid の無いトークンを検証する）
+	if err := utils.RegisterLegacyJWTKey(); err != nil {
+		panic("Failed to register legacy JWT key: " + err.Error())
+	}
+
+	// 管理コマンド（例: ./main jwt-keys rotate）が指定された場合はサーバーを起動せずに終了する
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	if !utils.HasJWTSigningKey() {
+		panic("JWT_SECRET environment variable is not set and no JWT key is registered. Please set it for security.")
+	}
+
+	if _, err := utils.LoadBuildPipeline(); err != nil {
+		panic("Failed to load build pipeline: " + err.Error())
+	}
+
+	utils.StartAuditRetention()
+	utils.RecoverInterruptedBuilds()
+	utils.StartBuildRetention()
+	utils.StartAnalyticsRollup()
+	utils.StartPrivacyRetention()
+	utils.StartLikeReconciliation()
+
 	router := gin.Default()
 	routes.SetupRoutes(router)
 
-	router.Run()
+	// router.Run() と同じく PORT（デフォルト8080）で待ち受ける
+	srv := &http.Server{Addr: ":" + config.GetEnv("PORT", "8080"), Handler: router}
+	// Shutdown はリクエストのコンテキストをキャンセルしないため、SSE の接続はこちらで閉じる
+	srv.RegisterOnShutdown(utils.CloseEventStreams)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic("Failed to start server: " + err.Error())
+		}
+	}()
+
+	// SIGINT / SIGTERM を受け取ったら新しいリクエストの受け付けを止め、書き出し待ちの PV を保存してから終了する
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("サーバーを終了します...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second))
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		// SSE の接続などが残っていても PV の保存は行う
+		log.Printf("サーバーの停止がタイムアウトしました: %v", err)
+	}
+	if err := utils.FlushPageViews(); err != nil {
+		log.Printf("[PageView] 終了時の PV の書き出しに失敗: %v", err)
+	}
 }
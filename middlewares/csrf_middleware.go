@@ -1,18 +1,144 @@
 package middlewares
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
-	csrf "github.com/utrack/gin-csrf"
 )
 
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfCookieTTL  = 3600 * 24 * 7
+)
+
+var (
+	csrfSecretOnce sync.Once
+	csrfSecret     []byte
+)
+
+// getCSRFSecret は CSRF_SECRET を返す。未設定の場合は起動ごとにランダムな値を生成する
+// （再起動後は古いトークンが無効になり、クライアントは再取得が必要になる）。
+func getCSRFSecret() []byte {
+	csrfSecretOnce.Do(func() {
+		if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+			csrfSecret = []byte(secret)
+			return
+		}
+		log.Println("Warning: CSRF_SECRET環境変数が設定されていません。ランダムな値を使用します。")
+		csrfSecret = make([]byte, 32)
+		if _, err := rand.Read(csrfSecret); err != nil {
+			panic("Failed to generate CSRF secret.")
+		}
+	})
+	return csrfSecret
+}
+
+func signCSRFNonce(nonce string) string {
+	mac := hmac.New(sha256.New, getCSRFSecret())
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newCSRFToken は "nonce.署名" 形式のトークンを生成する。
+// 署名付きにすることで、サブドメイン等から攻撃者が用意した Cookie を植え付けられても検証に通らない。
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return nonce + "." + signCSRFNonce(nonce), nil
+}
+
+func validCSRFToken(token string) bool {
+	nonce, sig, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signCSRFNonce(nonce)))
+}
+
+// IssueCSRFToken は CSRF トークンを発行して Cookie にセットし、レスポンスでも返す。
+// フロントエンドは状態を変更するリクエストで X-CSRF-Token ヘッダーにこの値を付与する（ダブルサブミット）。
+func IssueCSRFToken(c *gin.Context) {
+	token, err := c.Cookie(csrfCookieName)
+	if err != nil || !validCSRFToken(token) {
+		token, err = newCSRFToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSRF token"})
+			return
+		}
+	}
+
+	// JS から読み取れるよう HttpOnly にはしない
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookieName, token, csrfCookieTTL, "/", "www.katori.dev", true, false)
+	c.JSON(http.StatusOK, gin.H{"csrf_token": token})
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sameOrigin は Origin（無ければ Referer）ヘッダーが許可されたオリジンかを判定する。
+// どちらのヘッダーも無い場合はトークン検証に委ねる。
+func sameOrigin(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		referer := c.GetHeader("Referer")
+		if referer == "" {
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	return origin == allowedOrigin
+}
+
+// CSRFMiddleware は Cookie 認証された状態変更リクエスト（POST/PUT/PATCH/DELETE）に対して
+// CSRF トークンを検証する。AuthMiddleware の後に使用すること。
+// Authorization: Bearer で認証されたリクエストはブラウザが自動送信しないため対象外とする。
 func CSRFMiddleware() gin.HandlerFunc {
-	return csrf.Middleware(csrf.Options{
-		Secret: os.Getenv("CSRF_SECRET"),
-		ErrorFunc: func(c *gin.Context) {
-			c.JSON(403, gin.H{"error": "CSRF token mismatch"})
+	return func(c *gin.Context) {
+		if isSafeMethod(c.Request.Method) || c.GetString("auth_method") == authMethodBearer {
+			c.Next()
+			return
+		}
+
+		if !sameOrigin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token mismatch"})
 			c.Abort()
-		},
-	})
+			return
+		}
+
+		cookieToken, err := c.Cookie(csrfCookieName)
+		headerToken := c.GetHeader(csrfHeaderName)
+		if err != nil || headerToken == "" ||
+			subtle.ConstantTimeCompare([]byte(cookieToken), []byte(headerToken)) != 1 ||
+			!validCSRFToken(headerToken) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token mismatch"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
 }
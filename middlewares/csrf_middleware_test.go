@@ -0,0 +1,135 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCSRFTestRouter は CSRFMiddleware を通るルートと CSRF トークンの発行ルートを持つルーターを返す。
+// AuthMiddleware の代わりに、テスト用のヘッダーで認証方式（Cookie / Bearer）だけを設定する。
+func newCSRFTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/csrf-token", IssueCSRFToken)
+
+	protected := r.Group("/api")
+	protected.Use(func(c *gin.Context) {
+		c.Set("auth_method", c.GetHeader("X-Test-Auth-Method"))
+		c.Next()
+	}, CSRFMiddleware())
+	protected.GET("/articles/drafts", func(c *gin.Context) { c.Status(http.StatusOK) })
+	protected.POST("/articles/add", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+// issueTestCSRFToken は /api/csrf-token からトークンを取得し、Cookie とレスポンスの値が一致することを確認する。
+func issueTestCSRFToken(t *testing.T, r *gin.Engine) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/csrf-token", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("csrf-token: status = %d, want 200", w.Code)
+	}
+
+	var body struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.CSRFToken == "" {
+		t.Fatalf("csrf-token: invalid response %s", w.Body.String())
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == csrfCookieName {
+			if cookie.Value != body.CSRFToken {
+				t.Fatalf("csrf-token: cookie %q does not match response %q", cookie.Value, body.CSRFToken)
+			}
+			if cookie.HttpOnly {
+				t.Error("csrf-token: cookie must be readable from JavaScript")
+			}
+			return body.CSRFToken
+		}
+	}
+	t.Fatal("csrf-token: cookie is not set")
+	return ""
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	r := newCSRFTestRouter()
+	token := issueTestCSRFToken(t, r)
+	other := issueTestCSRFToken(t, r) // 別のセッションで発行された正規のトークン
+
+	tests := []struct {
+		name       string
+		method     string
+		authMethod string
+		cookie     string
+		header     string
+		origin     string
+		referer    string
+		want       int
+	}{
+		{name: "Cookie認証でトークンが無いPOSTは拒否", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, want: http.StatusForbidden},
+		{name: "Cookie認証でCookieも無いPOSTは拒否", method: http.MethodPost, authMethod: authMethodCookie, header: token, want: http.StatusForbidden},
+		{name: "Cookieと一致しないトークンは拒否", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, header: other, want: http.StatusForbidden},
+		{name: "署名の無いトークンは一致していても拒否", method: http.MethodPost, authMethod: authMethodCookie, cookie: "forged.token", header: "forged.token", want: http.StatusForbidden},
+		{name: "別オリジンのOriginは拒否", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, header: token, origin: "https://evil.example.com", want: http.StatusForbidden},
+		{name: "別オリジンのRefererは拒否", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, header: token, referer: "https://evil.example.com/page", want: http.StatusForbidden},
+		{name: "ダブルサブミットが正しいPOSTは許可", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, header: token, want: http.StatusOK},
+		{name: "同一オリジンのOriginとトークンがあるPOSTは許可", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, header: token, origin: allowedOrigin, want: http.StatusOK},
+		{name: "同一オリジンのRefererとトークンがあるPOSTは許可", method: http.MethodPost, authMethod: authMethodCookie, cookie: token, header: token, referer: allowedOrigin + "/admin", want: http.StatusOK},
+		{name: "Bearer認証のPOSTはトークン無しで許可", method: http.MethodPost, authMethod: authMethodBearer, want: http.StatusOK},
+		{name: "GETはトークン無しで許可", method: http.MethodGet, authMethod: authMethodCookie, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/api/articles/add"
+			if tt.method == http.MethodGet {
+				path = "/api/articles/drafts"
+			}
+			req := httptest.NewRequest(tt.method, path, nil)
+			req.Header.Set("X-Test-Auth-Method", tt.authMethod)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d (body=%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIssueCSRFTokenReusesValidCookie(t *testing.T) {
+	r := newCSRFTestRouter()
+	token := issueTestCSRFToken(t, r)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/csrf-token", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response %s", w.Body.String())
+	}
+	if body.CSRFToken != token {
+		t.Errorf("token was reissued: got %q, want %q", body.CSRFToken, token)
+	}
+}
@@ -4,32 +4,41 @@ import (
 	"errors"
 	"k-cms/config"
 	"k-cms/models"
+	"k-cms/utils"
 	"net/http"
-	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid/v5"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// 認証方式（CSRF チェックの要否判定に使用）
+const (
+	authMethodCookie = "cookie"
+	authMethodBearer = "bearer"
+)
+
 // トークン認証用のミドルウェア
 func AuthMiddleware() gin.HandlerFunc {
 
-	// Cookieのチェック
 	return func(c *gin.Context) {
-		tokenString, err := c.Cookie("auth_token")
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication token is required."})
-			c.Abort()
-			return
+		// Authorization: Bearer ヘッダーを優先し、無ければCookieのチェック
+		authMethod := authMethodBearer
+		tokenString, hasBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !hasBearer || tokenString == "" {
+			authMethod = authMethodCookie
+			var err error
+			tokenString, err = c.Cookie("auth_token")
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication token is required."})
+				c.Abort()
+				return
+			}
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrTokenSignatureInvalid
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		}, jwt.WithValidMethods([]string{"HS256"}))
+		// kid に対応する鍵で検証（ローテーション前の鍵で署名されたトークンも退役までは有効）
+		token, err := utils.ParseAuthToken(tokenString)
 
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed."})
@@ -58,10 +67,20 @@ func AuthMiddleware() gin.HandlerFunc {
 				return
 			}
 
+			// パスワード再設定などで無効化された世代のトークンは拒否する
+			// （ver クレームを持たない旧トークンは世代0として扱う）
+			tokenVersion, _ := claims["ver"].(float64)
+			if int(tokenVersion) != user.TokenVersion {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked."})
+				c.Abort()
+				return
+			}
+
 			// ここでリクエスト処理前にContextに対して"user_id"と"user"をセット
 			// リクエスト処理中にこれらの値を取得できるようになる
 			c.Set("user_id", userID)
 			c.Set("user", user)
+			c.Set("auth_method", authMethod)
 			c.Next()
 		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token."})
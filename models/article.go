@@ -19,14 +19,25 @@ func (sa StringArray) Value() (driver.Value, error) {
 	return json.Marshal(sa)
 }
 func (sa *StringArray) Scan(value interface{}) error {
-	bytes, ok := value.([]byte)
-	if !ok {
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string: // SQLite などドライバによっては TEXT 列が string で返る
+		bytes = []byte(v)
+	default:
 		return errors.New("failed to unmarshal StringArray value")
 	}
 
 	return json.Unmarshal(bytes, &sa)
 }
 
+// 記事の公開状態
+const (
+	ArticleStatusDraft     = "draft"     // 下書き（公開APIや静的サイトには含めず、プレビューリンクでのみ閲覧できる）
+	ArticleStatusPublished = "published" // 公開
+)
+
 type Article struct {
 	gorm.Model
 	ID            uuid.UUID   `gorm:"type:char(36);primaryKey" json:"id"`
@@ -38,6 +49,7 @@ type Article struct {
 	Datetime      string      `gorm:"type:date;not null;index" json:"datetime"`
 	Content       string      `gorm:"type:longtext;not null" json:"content"`
 	LikeCount     int         `gorm:"default:0;not null" json:"like_count"`
+	Status        string      `gorm:"size:16;not null;default:'published';index" json:"status"`
 
 	UserID uuid.UUID `gorm:"type:char(36);not null;index" json:"user_id"`
 	User   User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// ArticleDailyStat は記事ごと・日ごとのPV数といいね数の集計（ロールアップ）。
+// page_views と likes から定期的に再集計され、カウンターや時系列の取得ではこちらを参照する。
+type ArticleDailyStat struct {
+	ArticleID uuid.UUID `gorm:"type:char(36);primaryKey" json:"article_id"`
+	Date      time.Time `gorm:"type:date;primaryKey;index" json:"date"`
+	Views     int64     `gorm:"not null;default:0" json:"views"`
+	Likes     int64     `gorm:"not null;default:0" json:"likes"` // その日に付いたいいねのうち、取り消されていないもの
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ArticleDailyStat) TableName() string {
+	return "article_daily_stats"
+}
+
+// MigrateArticleDailyStat はテーブル作成を行う。
+func MigrateArticleDailyStat(db *gorm.DB) error {
+	return db.AutoMigrate(&ArticleDailyStat{})
+}
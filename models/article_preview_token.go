@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// ArticlePreviewToken は記事のプレビューリンクの発行記録。
+// トークン本体は署名付き JWT で保存せず、jti として ID を埋め込む。失効は RevokedAt で管理する。
+type ArticlePreviewToken struct {
+	ID             uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	CreatedAt      time.Time  `gorm:"not null" json:"created_at"`
+	ArticleID      uuid.UUID  `gorm:"type:char(36);not null;index" json:"article_id"`
+	CreatedBy      uuid.UUID  `gorm:"type:char(36);not null" json:"created_by"`
+	Note           string     `gorm:"size:255" json:"note"` // 共有相手のメモ（例: レビュー担当者名）
+	ExpiresAt      time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	RevokedBy      *uuid.UUID `gorm:"type:char(36)" json:"revoked_by"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	AccessCount    int        `gorm:"not null;default:0" json:"access_count"`
+}
+
+func (ArticlePreviewToken) TableName() string {
+	return "article_preview_tokens"
+}
+
+func (t *ArticlePreviewToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigrateArticlePreviewToken はテーブル作成を行う。
+func MigrateArticlePreviewToken(db *gorm.DB) error {
+	return db.AutoMigrate(&ArticlePreviewToken{})
+}
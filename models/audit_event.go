@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// AuditEvent は管理操作の監査ログ。
+// Before / After は変更前後のスナップショット、Diff は変更のあったフィールドのみを JSON で保持する。
+type AuditEvent struct {
+	ID         uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	CreatedAt  time.Time  `gorm:"not null;index" json:"created_at"`
+	ActorID    *uuid.UUID `gorm:"type:char(36);index" json:"actor_id"`
+	ActorName  string     `gorm:"size:255" json:"actor_name"`
+	Action     string     `gorm:"size:64;not null;index" json:"action"`
+	TargetType string     `gorm:"size:64;not null;index:idx_audit_target" json:"target_type"`
+	TargetID   string     `gorm:"size:64;index:idx_audit_target" json:"target_id"`
+	Before     string     `gorm:"type:longtext" json:"before,omitempty"`
+	After      string     `gorm:"type:longtext" json:"after,omitempty"`
+	Diff       string     `gorm:"type:longtext" json:"diff,omitempty"`
+	IPAddress  string     `gorm:"type:varchar(45)" json:"ip_address"`
+	UserAgent  string     `gorm:"size:512" json:"user_agent"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+func (e *AuditEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigrateAuditEvent はテーブル作成を行う。
+func MigrateAuditEvent(db *gorm.DB) error {
+	return db.AutoMigrate(&AuditEvent{})
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// Build の状態
+const (
+	BuildStateQueued   = "queued"
+	BuildStateRunning  = "running"
+	BuildStateSuccess  = "success"
+	BuildStateFailed   = "failed"
+	BuildStateCanceled = "canceled"
+	BuildStateSkipped  = "skipped" // ターゲットのみ（直列実行で前のターゲットが失敗した場合など）
+)
+
+// Build はフロントエンドビルド1回分の履歴。
+// ID はビルドキューのジョブIDと同じ値を使う。
+type Build struct {
+	gorm.Model
+	ID            uuid.UUID   `gorm:"type:char(36);primaryKey" json:"id"`
+	TriggerSource string      `gorm:"size:32;not null;index" json:"trigger_source"` // article, site_config, manual, retry, batch
+	Action        string      `gorm:"size:32;not null" json:"action"`
+	Actions       StringArray `gorm:"type:text" json:"actions"` // まとめられたアクションの一覧
+	ArticleIDs    StringArray `gorm:"type:text;not null" json:"article_ids"`
+	UserID        *uuid.UUID  `gorm:"type:char(36);index" json:"user_id"`
+	RetryOf       *uuid.UUID  `gorm:"type:char(36);index" json:"retry_of"` // 再実行元のビルド
+	CanceledBy    *uuid.UUID  `gorm:"type:char(36)" json:"canceled_by"`    // キャンセルを要求したユーザー
+	State         string      `gorm:"size:16;not null;index" json:"state"`
+	QueuedAt      time.Time   `gorm:"not null" json:"queued_at"`
+	StartedAt     *time.Time  `json:"started_at"`
+	FinishedAt    *time.Time  `json:"finished_at"`
+	ExitCode      *int        `json:"exit_code"`
+	Error         string      `gorm:"type:text" json:"error"`
+
+	Targets []BuildTarget `gorm:"foreignKey:BuildID" json:"targets,omitempty"`
+}
+
+func (Build) TableName() string {
+	return "builds"
+}
+
+func (b *Build) BeforeCreate(tx *gorm.DB) (err error) {
+	if b.ID == uuid.Nil {
+		b.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// BuildLogLine はビルドログの1行。Seq はビルド内で1から始まる連番。
+type BuildLogLine struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	BuildID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:uq_build_log_seq" json:"build_id"`
+	Seq       int       `gorm:"not null;uniqueIndex:uq_build_log_seq" json:"seq"`
+	Target    string    `gorm:"size:64" json:"target,omitempty"` // 出力したパイプラインのターゲット（ビルド全体のログは空）
+	Line      string    `gorm:"type:text;not null" json:"line"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (BuildLogLine) TableName() string {
+	return "build_log_lines"
+}
+
+// BuildTarget はビルド内で実行したパイプラインのターゲット1つ分の結果。
+type BuildTarget struct {
+	ID         uint       `gorm:"primaryKey" json:"-"`
+	BuildID    uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:uq_build_target" json:"build_id"`
+	Name       string     `gorm:"size:64;not null;uniqueIndex:uq_build_target" json:"name"`
+	State      string     `gorm:"size:16;not null" json:"state"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	ExitCode   *int       `json:"exit_code"`
+	Error      string     `gorm:"type:text" json:"error"`
+}
+
+func (BuildTarget) TableName() string {
+	return "build_targets"
+}
+
+// MigrateBuild はテーブル作成を行う。
+func MigrateBuild(db *gorm.DB) error {
+	return db.AutoMigrate(&Build{}, &BuildLogLine{}, &BuildTarget{})
+}
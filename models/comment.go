@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// コメントのモデレーション状態
+const (
+	CommentStatusPending  = "pending"  // 承認待ち（公開されない）
+	CommentStatusApproved = "approved" // 公開中
+	CommentStatusSpam     = "spam"     // スパム・ボット・投稿禁止された読者のもの（公開されない）
+)
+
+// Comment は記事への読者のコメント。いいねと同じく fingerprint で投稿者を識別する。
+// ParentID を指定したものは返信で、公開されるのは親を含めて承認済みのコメントのみ。
+type Comment struct {
+	gorm.Model
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	ArticleID   uuid.UUID  `gorm:"type:char(36);not null;index:idx_comment_article_status" json:"article_id"`
+	ParentID    *uuid.UUID `gorm:"type:char(36);index" json:"parent_id"`
+	Fingerprint string     `gorm:"type:varchar(255);not null;index" json:"fingerprint"`
+	DisplayName string     `gorm:"type:varchar(50);not null;default:''" json:"display_name"` // 空の場合は匿名として表示する
+	Body        string     `gorm:"type:text;not null" json:"body"`
+	Status      string     `gorm:"type:varchar(16);not null;default:'pending';index:idx_comment_article_status;index" json:"status"`
+	IPAddress   string     `gorm:"type:varchar(45);not null" json:"ip_address"`
+	UserAgent   string     `gorm:"type:varchar(255);not null;default:''" json:"user_agent"`
+	BotReason   string     `gorm:"type:varchar(32);not null;default:''" json:"bot_reason"` // ボットと判定した場合の理由（spam として受け付ける）
+	ModeratedAt *time.Time `json:"moderated_at"`
+	ModeratedBy *uuid.UUID `gorm:"type:char(36)" json:"moderated_by"`
+
+	Article Article `gorm:"foreignKey:ArticleID" json:"article,omitempty"`
+}
+
+func (Comment) TableName() string {
+	return "comments"
+}
+
+func (cm *Comment) BeforeCreate(tx *gorm.DB) (err error) {
+	if cm.ID == uuid.Nil {
+		cm.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// CommentBan はコメントの投稿を禁止した fingerprint
+type CommentBan struct {
+	ID          uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
+	Fingerprint string    `gorm:"type:varchar(255);not null;uniqueIndex" json:"fingerprint"`
+	Reason      string    `gorm:"size:255" json:"reason"`
+	CreatedBy   uuid.UUID `gorm:"type:char(36);not null" json:"created_by"`
+}
+
+func (CommentBan) TableName() string {
+	return "comment_bans"
+}
+
+func (b *CommentBan) BeforeCreate(tx *gorm.DB) (err error) {
+	if b.ID == uuid.Nil {
+		b.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigrateComment はテーブル作成を行う。
+func MigrateComment(db *gorm.DB) error {
+	return db.AutoMigrate(&Comment{}, &CommentBan{})
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// Deploy の状態
+const (
+	DeployStatusActive   = "active"   // current シンボリックリンクが指しているリリース
+	DeployStatusInactive = "inactive" // 保持されていてロールバック可能なリリース
+	DeployStatusPruned   = "pruned"   // 保持数を超えて削除されたリリース
+)
+
+// Deploy はパイプラインのターゲットが公開したリリース1件。
+// リリースは Root/releases/ 以下のディレクトリに置かれ、Root/current シンボリックリンクの切り替えで公開される。
+type Deploy struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	CreatedAt   time.Time  `gorm:"not null;index" json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	BuildID     uuid.UUID  `gorm:"type:char(36);not null;index" json:"build_id"`
+	Target      string     `gorm:"size:64;not null;index" json:"target"`
+	Root        string     `gorm:"size:512;not null;index" json:"root"`
+	ReleaseDir  string     `gorm:"size:512;not null" json:"release_dir"`
+	Status      string     `gorm:"size:16;not null;index" json:"status"`
+	ActivatedAt *time.Time `json:"activated_at"`
+	ActivatedBy *uuid.UUID `gorm:"type:char(36)" json:"activated_by"` // ロールバックしたユーザー（ビルドによる公開は nil）
+}
+
+func (Deploy) TableName() string {
+	return "deploys"
+}
+
+func (d *Deploy) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigrateDeploy はテーブル作成を行う。
+func MigrateDeploy(db *gorm.DB) error {
+	return db.AutoMigrate(&Deploy{})
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JWTKey の状態
+const (
+	JWTKeyStatusActive   = "active"   // 新規トークンの署名に使用する鍵（常に1つ）
+	JWTKeyStatusPrevious = "previous" // 署名には使わないが、発行済みトークンの検証には使う鍵
+	JWTKeyStatusRetired  = "retired"  // 検証にも使わない鍵
+)
+
+// JWTKey は JWT の署名鍵を kid 付きで保持する。
+// Secret は HS256 の場合は共通鍵、EdDSA の場合は Ed25519 秘密鍵の seed を base64 で格納する。
+type JWTKey struct {
+	gorm.Model
+	Kid       string     `gorm:"size:64;not null;uniqueIndex" json:"kid"`
+	Algorithm string     `gorm:"size:16;not null" json:"algorithm"` // HS256 or EdDSA
+	Secret    string     `gorm:"type:text;not null" json:"-"`
+	Status    string     `gorm:"size:16;not null;index" json:"status"`
+	RetiredAt *time.Time `json:"retired_at"`
+}
+
+func (JWTKey) TableName() string {
+	return "jwt_keys"
+}
+
+// MigrateJWTKey はテーブル作成を行う。
+func MigrateJWTKey(db *gorm.DB) error {
+	return db.AutoMigrate(&JWTKey{})
+}
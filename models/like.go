@@ -5,16 +5,27 @@ import (
 	"gorm.io/gorm"
 )
 
+// DefaultReaction は従来のいいねに当たるリアクション。Article.LikeCount はこのリアクションの数を表す。
+const DefaultReaction = "like"
+
+// Like は記事へのリアクション1件（従来のいいねは Reaction が "like" のもの）。
+// 同じ fingerprint でも種類が違えば同じ記事に複数のリアクションを付けられる。
 type Like struct {
 	gorm.Model
 	ID          uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
-	ArticleID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:unique_article_fingerprint" json:"article_id"`
-	Fingerprint string    `gorm:"type:varchar(255);not null;uniqueIndex:unique_article_fingerprint" json:"fingerprint"`
+	ArticleID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:uq_like_article_fp_reaction" json:"article_id"`
+	Fingerprint string    `gorm:"type:varchar(255);not null;uniqueIndex:uq_like_article_fp_reaction" json:"fingerprint"`
+	Reaction    string    `gorm:"type:varchar(32);not null;default:'like';uniqueIndex:uq_like_article_fp_reaction" json:"reaction"`
 	IPAddress   string    `gorm:"type:varchar(45);not null" json:"ip_address"`
 	Article     Article   `gorm:"foreignKey:ArticleID" json:"article,omitempty"`
+
+	// ボット判定（ボットと判定したいいねは記録するが like_count には数えない）
+	IsBot     bool   `gorm:"not null;default:false;index" json:"is_bot"`
+	BotReason string `gorm:"type:varchar(32);not null;default:''" json:"bot_reason"` // user_agent, headless, velocity
+	UserAgent string `gorm:"type:varchar(255);not null;default:''" json:"user_agent"`
 }
 
-// 複合ユニークキー：同じ記事に同じfingerprintからは1回のみいいねできる
+// 複合ユニークキー：同じ記事に同じfingerprintからは種類ごとに1回のみリアクションできる
 func (Like) TableName() string {
 	return "likes"
 }
@@ -32,6 +43,14 @@ func (Like) TableOptions() string {
 }
 
 // AutoMigrateで実行されるSQL
+// 既存のいいねは reaction カラムのデフォルト値により "like" のリアクションになる。
+// 種類ごとのユニーク制約を作成した後で、旧来の (article_id, fingerprint) のユニーク制約を削除する。
 func MigrateLike(db *gorm.DB) error {
-	return db.AutoMigrate(&Like{})
+	if err := db.AutoMigrate(&Like{}); err != nil {
+		return err
+	}
+	if db.Migrator().HasIndex(&Like{}, "unique_article_fingerprint") {
+		return db.Migrator().DropIndex(&Like{}, "unique_article_fingerprint")
+	}
+	return nil
 }
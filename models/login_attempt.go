@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// LoginAttempt はログイン試行の履歴を記録する。
+// 存在しないユーザー名に対する試行も UserID なしで記録する。
+type LoginAttempt struct {
+	gorm.Model
+	ID        uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	Username  string     `gorm:"size:255;not null;index" json:"username"`
+	UserID    *uuid.UUID `gorm:"type:char(36);index" json:"user_id"`
+	IPAddress string     `gorm:"type:varchar(45);not null;index" json:"ip_address"`
+	UserAgent string     `gorm:"size:512" json:"user_agent"`
+	Success   bool       `gorm:"not null;default:false" json:"success"`
+	Reason    string     `gorm:"size:32" json:"reason"` // success, unknown_user, bad_password, locked
+}
+
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+func (la *LoginAttempt) BeforeCreate(tx *gorm.DB) (err error) {
+	if la.ID == uuid.Nil {
+		la.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigrateLoginAttempt はテーブル作成を行う。
+func MigrateLoginAttempt(db *gorm.DB) error {
+	return db.AutoMigrate(&LoginAttempt{})
+}
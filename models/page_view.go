@@ -10,14 +10,31 @@ import (
 // PageView は1記事・1ユーザー・1日を1レコードとして記録する。
 // (article_id, fingerprint, visited_date) の複合ユニーク制約により
 // 同一日の再訪問はDBレベルで弾かれる。
+// 日ごとの集計は ArticleDailyStat にロールアップされる（idx_pageview_article_date は当日分の集計用）。
 type PageView struct {
 	gorm.Model
 	ID          uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
-	ArticleID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:uq_pageview_article_fp_date" json:"article_id"`
+	ArticleID   uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:uq_pageview_article_fp_date;index:idx_pageview_article_date" json:"article_id"`
 	Fingerprint string    `gorm:"type:varchar(255);not null;uniqueIndex:uq_pageview_article_fp_date" json:"fingerprint"`
 	IPAddress   string    `gorm:"type:varchar(45);not null" json:"ip_address"`
-	VisitedDate time.Time `gorm:"type:date;not null;uniqueIndex:uq_pageview_article_fp_date" json:"visited_date"`
-	Article     Article   `gorm:"foreignKey:ArticleID" json:"article,omitempty"`
+	VisitedDate time.Time `gorm:"type:date;not null;uniqueIndex:uq_pageview_article_fp_date;index:idx_pageview_article_date" json:"visited_date"`
+
+	// 流入元と閲覧環境（その日の最初の閲覧時のもの）
+	ReferrerHost string `gorm:"type:varchar(255);not null;default:''" json:"referrer_host"`
+	Source       string `gorm:"type:varchar(32);not null;default:'';index" json:"source"` // x, misskey, qiita, search, direct など
+	UTMSource    string `gorm:"column:utm_source;type:varchar(100);not null;default:''" json:"utm_source"`
+	UTMMedium    string `gorm:"column:utm_medium;type:varchar(100);not null;default:''" json:"utm_medium"`
+	UTMCampaign  string `gorm:"column:utm_campaign;type:varchar(100);not null;default:''" json:"utm_campaign"`
+	Language     string `gorm:"type:varchar(16);not null;default:''" json:"language"`
+	DeviceClass  string `gorm:"type:varchar(16);not null;default:''" json:"device_class"` // desktop, mobile, tablet, bot, unknown
+	Browser      string `gorm:"type:varchar(32);not null;default:''" json:"browser"`
+
+	// ボット判定（ボットと判定したアクセスも記録するが、公開カウントや集計からは除外する）
+	IsBot     bool   `gorm:"not null;default:false;index" json:"is_bot"`
+	BotReason string `gorm:"type:varchar(32);not null;default:''" json:"bot_reason"` // user_agent, headless, velocity
+	UserAgent string `gorm:"type:varchar(255);not null;default:''" json:"user_agent"`
+
+	Article Article `gorm:"foreignKey:ArticleID" json:"article,omitempty"`
 }
 
 func (PageView) TableName() string {
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// PasswordResetToken はパスワード再設定用のワンタイムトークン。
+// トークン本体は保存せず、SHA-256 ハッシュのみを保持する。
+type PasswordResetToken struct {
+	gorm.Model
+	ID        uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:char(36);not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RequestIP string     `gorm:"type:varchar(45)" json:"request_ip"`
+	User      User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+func (t *PasswordResetToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigratePasswordResetToken はテーブル作成を行う。
+func MigratePasswordResetToken(db *gorm.DB) error {
+	return db.AutoMigrate(&PasswordResetToken{})
+}
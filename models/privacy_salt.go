@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PrivacySalt は IP アドレスのハッシュ化に使う日ごとのソルト。
+// 日付が変わったら削除し、過去のハッシュから IP アドレスを総当たりで復元できないようにする。
+type PrivacySalt struct {
+	Date      time.Time `gorm:"type:date;primaryKey" json:"date"`
+	Salt      string    `gorm:"type:char(64);not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (PrivacySalt) TableName() string {
+	return "privacy_salts"
+}
+
+// MigratePrivacySalt はテーブル作成を行う。
+func MigratePrivacySalt(db *gorm.DB) error {
+	return db.AutoMigrate(&PrivacySalt{})
+}
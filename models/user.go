@@ -1,10 +1,23 @@
 package models
 
 import (
+	"time"
+
 	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
 )
 
+// ユーザーの権限
+const (
+	RoleAdmin  = "admin"  // すべての操作が可能
+	RoleEditor = "editor" // 記事・画像の管理のみ
+)
+
+// IsValidRole は role が定義済みの権限かを返す。
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleEditor
+}
+
 type User struct {
 	gorm.Model
 	ID       uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
@@ -16,6 +29,16 @@ type User struct {
 	TwitterUrl string    `gorm:"size:255" json:"twitter_url"`
 	QiitaUrl   string    `gorm:"size:255" json:"qiita_url"`
 	MisskeyUrl string    `gorm:"size:255" json:"misskey_url"`
+
+	// アカウントロック用（連続失敗回数とロック解除時刻）
+	FailedLoginCount int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil      *time.Time `json:"-"`
+
+	// 権限（既存ユーザーは admin、OIDC の自動作成ユーザーは OIDC_DEFAULT_ROLE）
+	Role string `gorm:"size:32;not null;default:'admin'" json:"role"`
+
+	// 発行済みトークンの世代。パスワード再設定時にインクリメントして既存のログインを無効化する
+	TokenVersion int `gorm:"not null;default:0" json:"-"`
 }
 
 func NewUUIDv7() uuid.UUID {
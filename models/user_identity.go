@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// UserIdentity は外部IDプロバイダ（OIDC）のアカウントと User の紐付けを保持する。
+// (issuer, subject) の組でプロバイダ上のアカウントを一意に識別する。
+type UserIdentity struct {
+	gorm.Model
+	ID      uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID  uuid.UUID `gorm:"type:char(36);not null;index" json:"user_id"`
+	Issuer  string    `gorm:"size:255;not null;uniqueIndex:uq_identity_issuer_subject" json:"issuer"`
+	Subject string    `gorm:"size:255;not null;uniqueIndex:uq_identity_issuer_subject" json:"subject"`
+	Email   string    `gorm:"size:255" json:"email"`
+	User    User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+func (ui *UserIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	if ui.ID == uuid.Nil {
+		ui.ID = NewUUIDv7()
+	}
+	return nil
+}
+
+// MigrateUserIdentity はテーブル作成を行う。
+func MigrateUserIdentity(db *gorm.DB) error {
+	return db.AutoMigrate(&UserIdentity{})
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"k-cms/controllers"
 	"k-cms/middlewares"
+	"k-cms/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,13 +15,29 @@ func SetupRoutes(r *gin.Engine) {
 	public := r.Group("/api")
 	{
 		public.POST("/login", middlewares.LoginRateLimit(), controllers.Login)
+		public.POST("/forgot-password", middlewares.LoginRateLimit(), controllers.ForgotPassword)
+		public.POST("/reset-password", middlewares.LoginRateLimit(), controllers.ResetPassword)
+		public.GET("/oidc/login", middlewares.LoginRateLimit(), controllers.OIDCLogin)
+		public.GET("/oidc/callback", middlewares.LoginRateLimit(), controllers.OIDCCallback)
 		public.GET("/articles", controllers.GetArticles)
 		public.GET("/articles/:id", controllers.GetArticle)
+		public.GET("/preview/:token", middlewares.PublicRateLimit(), controllers.GetPreview) // 下書きのプレビューリンク
 		public.GET("/images/:filename", controllers.GetImage)
 		public.GET("/like-status/:id", controllers.GetLikeStatus)
+		public.GET("/reaction-status/:id", controllers.GetReactionStatus)
+		public.GET("/reactions", controllers.GetReactionTypes)
+
+		// いいね数・PV数のリアルタイム配信（Server-Sent Events）
+		public.GET("/articles/:id/counters/stream", middlewares.PublicRateLimit(), controllers.StreamArticleCounters)
+		public.GET("/counters/stream", middlewares.PublicRateLimit(), controllers.StreamCounters)
 
 		// いいね機能をpublicに移動（fingerprintで同一性を判定）
 		public.POST("/articles/like", middlewares.PublicRateLimit(), controllers.ToggleLike)
+		public.POST("/articles/react", middlewares.PublicRateLimit(), controllers.ToggleReaction)
+
+		// コメント（承認済みのもののみ公開、投稿は承認待ちになる）
+		public.GET("/articles/:id/comments", controllers.GetComments)
+		public.POST("/articles/:id/comments", middlewares.PublicRateLimit(), controllers.CreateComment)
 
 		// アクセスカウンター（fingerprint + 日付で重複防止）
 		public.POST("/articles/pageview", middlewares.PublicRateLimit(), controllers.RecordPageView)
@@ -29,14 +46,16 @@ func SetupRoutes(r *gin.Engine) {
 		public.GET("/users/:id", controllers.GetUser) // プロフィール表示用
 		public.GET("/owner", controllers.GetOwner)    // オーナープロフィール取得用（新規追加）
 		public.GET("/site-config", controllers.GetSiteConfig) // サイト設定用
+		public.GET("/csrf-token", middlewares.IssueCSRFToken)  // CSRFトークン取得用
 	}
 
 	protected := r.Group("/api")
-	protected.Use(middlewares.AuthMiddleware())
+	protected.Use(middlewares.AuthMiddleware(), middlewares.CSRFMiddleware())
 	{
 		// GET("/エンドポイント:XXX")でパスパラメータが取れる
 
 		protected.GET("/users", controllers.GetUsers)
+		protected.POST("/register", middlewares.RequireRole(models.RoleAdmin), controllers.Register)
 		protected.PUT("/users/:id", controllers.UpdateUser)
 		protected.DELETE("/users/:id", controllers.DeleteUser)
 		protected.POST("/change-password", controllers.ChangePassword)
@@ -44,6 +63,10 @@ func SetupRoutes(r *gin.Engine) {
 		protected.POST("/articles/add", controllers.AddArticle)
 		protected.PUT("/articles/:id", controllers.UpdateArticle)
 		protected.DELETE("/articles/:id", controllers.DeleteArticle)
+		protected.GET("/articles/drafts", controllers.GetDraftArticles)
+		protected.POST("/articles/:id/preview-tokens", controllers.CreatePreviewToken)
+		protected.GET("/articles/:id/preview-tokens", controllers.GetPreviewTokens)
+		protected.DELETE("/preview-tokens/:id", controllers.RevokePreviewToken)
 
 		protected.GET("/is_Auth", controllers.IsAuthenticated)
 		protected.POST("/logout", controllers.Logout)
@@ -52,8 +75,45 @@ func SetupRoutes(r *gin.Engine) {
 		protected.GET("/images", controllers.GetImages)
 		protected.DELETE("/images/:id", controllers.DeleteImage)
 		protected.GET("/build-status", controllers.GetBuildStatus)
+		protected.GET("/builds", controllers.GetBuilds)
+		protected.POST("/builds", middlewares.RequireRole(models.RoleAdmin), controllers.TriggerManualBuild)
+		protected.GET("/builds/:id", controllers.GetBuild)
+		protected.POST("/builds/:id/cancel", middlewares.RequireRole(models.RoleAdmin), controllers.CancelBuild)
+		protected.POST("/builds/:id/retry", middlewares.RequireRole(models.RoleAdmin), controllers.RetryBuild)
+		protected.GET("/builds/:id/log", controllers.GetBuildLog)
+		protected.GET("/builds/:id/stream", controllers.StreamBuild)
+
+		// アクセス解析は admin のみ
+		analytics := protected.Group("/analytics", middlewares.RequireRole(models.RoleAdmin))
+		analytics.GET("/timeseries", controllers.GetSiteStats)
+		analytics.GET("/articles/:id/timeseries", controllers.GetArticleStats)
+		analytics.GET("/top", controllers.GetTopArticles)
+		analytics.GET("/breakdown", controllers.GetSiteTrafficBreakdown)
+		analytics.GET("/articles/:id/breakdown", controllers.GetArticleTrafficBreakdown)
+
+		protected.GET("/deploys", controllers.GetDeploys)
+		protected.POST("/deploys/:id/rollback", middlewares.RequireRole(models.RoleAdmin), controllers.RollbackDeploy)
 
 		// protected.GET("/site-config", controllers.GetSiteConfig) // Publicに移動済み
-		protected.PUT("/site-config", controllers.UpdateSiteConfig)
+		protected.PUT("/site-config", middlewares.RequireRole(models.RoleAdmin), controllers.UpdateSiteConfig)
+
+		// アカウントロック・ログイン試行履歴の管理
+		admin := protected.Group("/admin", middlewares.RequireRole(models.RoleAdmin))
+		admin.GET("/login-attempts", controllers.GetLoginAttempts)
+		admin.GET("/locked-accounts", controllers.GetLockedAccounts)
+		admin.POST("/users/:id/unlock", controllers.UnlockAccount)
+		admin.GET("/audit-events", controllers.GetAuditEvents)
+		admin.GET("/bot-traffic", controllers.GetBotTraffic)
+		admin.GET("/bot-traffic/summary", controllers.GetBotTrafficSummary)
+		admin.POST("/privacy/erase", controllers.EraseFingerprintData)
+		admin.POST("/likes/reconcile", controllers.ReconcileLikeCounts)
+		admin.GET("/pageview-buffer", controllers.GetPageViewBufferStats)
+		admin.GET("/comments", controllers.GetModerationComments)
+		admin.POST("/comments/approve", controllers.ApproveComments)
+		admin.POST("/comments/reject", controllers.RejectComments)
+		admin.POST("/comments/ban", controllers.BanCommenters)
+		admin.GET("/comment-bans", controllers.GetCommentBans)
+		admin.DELETE("/comment-bans/:id", controllers.DeleteCommentBan)
+		admin.POST("/notifications/test", controllers.TestBuildNotification)
 	}
 }
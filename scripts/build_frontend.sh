@@ -4,11 +4,24 @@ set -euo pipefail
 # ビルドスクリプト - フロントエンドのビルドとデプロイを実行
 # 使用方法: ./build_frontend.sh <action> <article_id>
 # 例: ./build_frontend.sh create abc-123
+# 短時間に複数の変更があった場合はまとめて1回呼ばれ、action は "batch"、
+# article_id はカンマ区切り（例: abc-123,def-456）になる。内訳は BUILD_ACTIONS 環境変数で参照できる。
+# 管理画面からの手動ビルドでは action は "rebuild"、article_id は空になる。
+# 下書きのプレビュー用ビルドでは action は "preview" になり、BUILD_PREVIEW_TOKENS 環境変数（カンマ区切り）の
+# トークンで GET /api/preview/:token から下書きを取得できる（npm run build にもそのまま引き継がれる）。
+# キャンセル時はプロセスグループ全体に SIGTERM が送られ、猶予期間（BUILD_CANCEL_GRACE）後に SIGKILL される。
 
 LOG_PREFIX="[Frontend Build]"
-FRONTEND_DIR="/root/blog"
-BUILD_OUTPUT_DIR="/root/blog/out"
-DEPLOY_DIR="/var/www/html/blog"
+# パイプライン設定（BUILD_PIPELINE_CONFIG）の env で上書きできる
+FRONTEND_DIR="${FRONTEND_DIR:-/root/blog}"
+BUILD_OUTPUT_DIR="${BUILD_OUTPUT_DIR:-$FRONTEND_DIR/out}"
+DEPLOY_DIR="${DEPLOY_DIR:-/var/www/html/blog}"
+
+# アトミックデプロイが有効な場合は、サーバーが用意した新しいリリースディレクトリに出力する。
+# 公開（current シンボリックリンクの切り替え）はスクリプトの成功後にサーバー側で行う。
+if [ -n "${BUILD_RELEASE_DIR:-}" ]; then
+    DEPLOY_DIR="$BUILD_RELEASE_DIR"
+fi
 
 # 引数のチェック
 if [ $# -lt 2 ]; then
@@ -0,0 +1,316 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 時系列の集計単位
+const (
+	StatsIntervalDay   = "day"
+	StatsIntervalWeek  = "week" // 月曜始まり
+	StatsIntervalMonth = "month"
+)
+
+const statsDateLayout = "2006-01-02"
+
+// 時系列で返す期間の最大数（範囲の指定ミスで巨大なレスポンスを返さないため）
+const maxStatsPoints = 1000
+
+var ErrInvalidStatsRange = errors.New("invalid stats range")
+
+// rollupWatermark はロールアップが確定している日付の境界。
+// この日付より前の集計は完了しており、以降（当日分）は page_views から直接数える。
+var (
+	rollupMu        sync.RWMutex
+	rollupWatermark time.Time
+)
+
+func setRollupWatermark(t time.Time) {
+	rollupMu.Lock()
+	defer rollupMu.Unlock()
+	rollupWatermark = t
+}
+
+func getRollupWatermark() (time.Time, bool) {
+	rollupMu.RLock()
+	defer rollupMu.RUnlock()
+	return rollupWatermark, !rollupWatermark.IsZero()
+}
+
+// startOfDay はローカルタイムゾーンでの日付の0時を返す。
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// RollupDailyStats は from から to まで（両端を含む日付）の page_views と likes を article_daily_stats に集計し直す。
+// ボットと判定されたアクセスは集計に含めない。
+// 範囲内の集計は一度削除してから作り直すため、何度実行しても同じ結果になる。
+func RollupDailyStats(from, to time.Time) error {
+	from, to = startOfDay(from), startOfDay(to)
+	if to.Before(from) {
+		return ErrInvalidStatsRange
+	}
+	// 保持期間を過ぎて page_views を削除済みの日は集計し直さない（集計が0で上書きされるため）
+	if deletedBefore, ok := deletedRawBefore(); ok && from.Before(deletedBefore) {
+		log.Printf("[Analytics] %s より前は page_views を削除済みのため集計し直しません", deletedBefore.Format(statsDateLayout))
+		from = deletedBefore
+		if to.Before(from) {
+			return nil
+		}
+	}
+	fromStr, toStr := from.Format(statsDateLayout), to.Format(statsDateLayout)
+	end := to.AddDate(0, 0, 1)
+
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("date BETWEEN ? AND ?", fromStr, toStr).Delete(&models.ArticleDailyStat{}).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+INSERT INTO article_daily_stats (article_id, date, views, likes, updated_at)
+SELECT article_id, d, SUM(v), SUM(l), NOW() FROM (
+	SELECT article_id, visited_date AS d, COUNT(*) AS v, 0 AS l
+	FROM page_views
+	WHERE deleted_at IS NULL AND is_bot = FALSE AND visited_date BETWEEN ? AND ?
+	GROUP BY article_id, visited_date
+	UNION ALL
+	SELECT article_id, DATE(created_at) AS d, 0 AS v, COUNT(*) AS l
+	FROM likes
+	WHERE deleted_at IS NULL AND is_bot = FALSE AND reaction = 'like' AND created_at >= ? AND created_at < ?
+	GROUP BY article_id, DATE(created_at)
+) t
+GROUP BY article_id, d`, fromStr, toStr, from, end).Error
+	})
+}
+
+// rollupStartDate は集計を始める日付を返す。
+// article_daily_stats が page_views と likes の最も古い日付までさかのぼって集計済みであれば
+// 直近 ANALYTICS_ROLLUP_DAYS 日の先頭を、そうでなければ（初回や、analytics rollup コマンドで
+// 直近の期間だけを集計した後など）その最も古い日付を返す。
+func rollupStartDate() (time.Time, error) {
+	days := config.GetEnvInt("ANALYTICS_ROLLUP_DAYS", 2)
+	if days < 1 {
+		days = 1
+	}
+	start := startOfDay(time.Now()).AddDate(0, 0, -(days - 1))
+
+	// 集計対象と同じ条件で数え、集計すれば必ず article_daily_stats に行ができる日付だけを比べる
+	// （page_views を削除済みの範囲は RollupDailyStats でも集計し直さないため除く）
+	since := time.Time{}
+	if deletedBefore, ok := deletedRawBefore(); ok {
+		since = deletedBefore
+	}
+	var oldest struct {
+		Stat *time.Time
+		PV   *time.Time
+		Like *time.Time
+	}
+	err := config.DB.Raw(`SELECT
+	(SELECT MIN(date) FROM article_daily_stats) AS stat,
+	(SELECT MIN(visited_date) FROM page_views WHERE deleted_at IS NULL AND is_bot = FALSE AND visited_date >= ?) AS pv,
+	(SELECT MIN(created_at) FROM likes WHERE deleted_at IS NULL AND is_bot = FALSE AND reaction = 'like' AND created_at >= ?) AS `+"`like`",
+		since.Format(statsDateLayout), since).Scan(&oldest).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, t := range []*time.Time{oldest.PV, oldest.Like} {
+		if t == nil {
+			continue
+		}
+		day := startOfDay(*t)
+		if oldest.Stat != nil && !startOfDay(*oldest.Stat).After(day) {
+			continue // この日付まで集計済み
+		}
+		if day.Before(start) {
+			start = day
+		}
+	}
+	return start, nil
+}
+
+// runRollup は直近 ANALYTICS_ROLLUP_DAYS 日（未集計の期間があればその期間も含めて）を集計し、確定した日付の境界を更新する。
+// 境界は全期間の集計が終わってから設定するため、それまでの総PV数は page_views から数える。
+func runRollup() error {
+	// 書き出し待ちの PV を保存してから集計する（確定とした日付の PV が後から保存されないように）
+	if err := FlushPageViews(); err != nil {
+		return err
+	}
+	now := time.Now()
+	from, err := rollupStartDate()
+	if err != nil {
+		return err
+	}
+	if err := RollupDailyStats(from, now); err != nil {
+		return err
+	}
+	// 集計開始時点の日付より前の page_views はもう増えないため確定とする
+	setRollupWatermark(startOfDay(now))
+	return nil
+}
+
+// StartAnalyticsRollup は ANALYTICS_ROLLUP_INTERVAL（デフォルト10分）ごとに
+// 直近 ANALYTICS_ROLLUP_DAYS 日（デフォルト2日）の集計をやり直す。
+// いいねの取り消しなど、この期間より前の変更を反映する場合は analytics rollup コマンドで再集計する。
+func StartAnalyticsRollup() {
+	interval := config.GetEnvDuration("ANALYTICS_ROLLUP_INTERVAL", 10*time.Minute)
+
+	go func() {
+		for {
+			start := time.Now()
+			if err := runRollup(); err != nil {
+				log.Printf("[Analytics] 日次集計に失敗: %v", err)
+			} else {
+				log.Printf("[Analytics] 日次集計を更新しました: 所要時間=%v", time.Since(start))
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// ArticleViewCount は記事の総PV数を返す。
+// 確定済みの日は article_daily_stats から、それ以降は page_views から数える（集計前は page_views のみ）。
+func ArticleViewCount(articleID string) (int64, error) {
+	watermark, ok := getRollupWatermark()
+	if !ok {
+		var count int64
+		err := config.DB.Model(&models.PageView{}).Where("article_id = ? AND is_bot = ?", articleID, false).Count(&count).Error
+		return count, err
+	}
+
+	var total struct {
+		Rolled int64
+		Recent int64
+	}
+	boundary := watermark.Format(statsDateLayout)
+	err := config.DB.Raw(`SELECT
+	(SELECT COALESCE(SUM(views), 0) FROM article_daily_stats WHERE article_id = ? AND date < ?) AS rolled,
+	(SELECT COUNT(*) FROM page_views WHERE deleted_at IS NULL AND is_bot = FALSE AND article_id = ? AND visited_date >= ?) AS recent`,
+		articleID, boundary, articleID, boundary).Scan(&total).Error
+	return total.Rolled + total.Recent, err
+}
+
+// StatsPoint は時系列の1期間分の集計
+type StatsPoint struct {
+	Period string `json:"period"` // 期間の開始日（YYYY-MM-DD）
+	Views  int64  `json:"views"`
+	Likes  int64  `json:"likes"`
+}
+
+// periodStart は日付を含む期間の開始日を返す。
+func periodStart(t time.Time, interval string) time.Time {
+	t = startOfDay(t)
+	switch interval {
+	case StatsIntervalWeek:
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case StatsIntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	default:
+		return t
+	}
+}
+
+func nextPeriod(t time.Time, interval string) time.Time {
+	switch interval {
+	case StatsIntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case StatsIntervalMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// periodExpr は期間の開始日を返す SQL 式
+func periodExpr(interval string) string {
+	switch interval {
+	case StatsIntervalWeek:
+		return "DATE_FORMAT(DATE_SUB(date, INTERVAL WEEKDAY(date) DAY), '%Y-%m-%d')"
+	case StatsIntervalMonth:
+		return "DATE_FORMAT(date, '%Y-%m-01')"
+	default:
+		return "DATE_FORMAT(date, '%Y-%m-%d')"
+	}
+}
+
+// StatsTimeSeries は from から to まで（両端を含む日付）の PV数・いいね数を interval ごとに返す。
+// articleID が空の場合はサイト全体の集計を返す。データの無い期間も0として含める。
+func StatsTimeSeries(articleID, interval string, from, to time.Time) ([]StatsPoint, error) {
+	switch interval {
+	case StatsIntervalDay, StatsIntervalWeek, StatsIntervalMonth:
+	default:
+		return nil, fmt.Errorf("%w: unknown interval %q", ErrInvalidStatsRange, interval)
+	}
+	first, last := periodStart(from, interval), periodStart(to, interval)
+	if last.Before(first) {
+		return nil, ErrInvalidStatsRange
+	}
+
+	var points []StatsPoint
+	for p := first; !p.After(last); p = nextPeriod(p, interval) {
+		if len(points) >= maxStatsPoints {
+			return nil, fmt.Errorf("%w: too many periods", ErrInvalidStatsRange)
+		}
+		points = append(points, StatsPoint{Period: p.Format(statsDateLayout)})
+	}
+
+	query := config.DB.Model(&models.ArticleDailyStat{}).
+		Select(periodExpr(interval)+" AS period, SUM(views) AS views, SUM(likes) AS likes").
+		Where("date BETWEEN ? AND ?", startOfDay(from).Format(statsDateLayout), startOfDay(to).Format(statsDateLayout))
+	if articleID != "" {
+		query = query.Where("article_id = ?", articleID)
+	}
+	var rows []StatsPoint
+	if err := query.Group("period").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(points))
+	for i, p := range points {
+		index[p.Period] = i
+	}
+	for _, r := range rows {
+		if i, ok := index[r.Period]; ok {
+			points[i].Views = r.Views
+			points[i].Likes = r.Likes
+		}
+	}
+	return points, nil
+}
+
+// TopArticle はランキングの1件分
+type TopArticle struct {
+	ArticleID string `json:"article_id"`
+	Title     string `json:"title"`
+	Views     int64  `json:"views"`
+	Likes     int64  `json:"likes"`
+}
+
+// TopArticles は from から to まで（両端を含む日付）で metric（views または likes）の多い記事を返す。
+func TopArticles(from, to time.Time, metric string, limit int) ([]TopArticle, error) {
+	if metric != "views" && metric != "likes" {
+		return nil, fmt.Errorf("%w: unknown metric %q", ErrInvalidStatsRange, metric)
+	}
+	if startOfDay(to).Before(startOfDay(from)) {
+		return nil, ErrInvalidStatsRange
+	}
+
+	var top []TopArticle
+	err := config.DB.Table("article_daily_stats AS s").
+		Select("s.article_id, a.title, SUM(s.views) AS views, SUM(s.likes) AS likes").
+		Joins("JOIN articles a ON a.id = s.article_id AND a.deleted_at IS NULL").
+		Where("s.date BETWEEN ? AND ?", startOfDay(from).Format(statsDateLayout), startOfDay(to).Format(statsDateLayout)).
+		Group("s.article_id, a.title").
+		Order(metric + " DESC").
+		Limit(limit).
+		Scan(&top).Error
+	return top, err
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"encoding/json"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// AuditEntry は監査ログに記録する1件分の操作
+type AuditEntry struct {
+	ActorID    *uuid.UUID
+	ActorName  string
+	Action     string // 例: "article.delete", "site_config.update"
+	TargetType string
+	TargetID   string
+	Before     interface{} // 変更前の状態（nil 可）
+	After      interface{} // 変更後の状態（nil 可）
+	IPAddress  string
+	UserAgent  string
+}
+
+// toJSONMap は値を JSON 経由で map に変換する（json:"-" のフィールドは含まれない）。
+func toJSONMap(v interface{}) (map[string]interface{}, string) {
+	if v == nil {
+		return nil, ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, ""
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, string(data)
+	}
+	return m, string(data)
+}
+
+// auditDiff は変更のあったキーだけを {"key": {"before": x, "after": y}} の形で返す。
+func auditDiff(before, after map[string]interface{}) map[string]interface{} {
+	diff := map[string]interface{}{}
+	for k, b := range before {
+		if a, ok := after[k]; !ok || !reflect.DeepEqual(a, b) {
+			diff[k] = map[string]interface{}{"before": b, "after": after[k]}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			diff[k] = map[string]interface{}{"before": nil, "after": a}
+		}
+	}
+	return diff
+}
+
+// RecordAudit は監査ログを保存する。保存に失敗しても呼び出し元の処理は継続させる。
+func RecordAudit(entry AuditEntry) {
+	beforeMap, beforeJSON := toJSONMap(entry.Before)
+	afterMap, afterJSON := toJSONMap(entry.After)
+
+	var diffJSON string
+	if beforeMap != nil || afterMap != nil {
+		if data, err := json.Marshal(auditDiff(beforeMap, afterMap)); err == nil {
+			diffJSON = string(data)
+		}
+	}
+
+	event := models.AuditEvent{
+		ActorID:    entry.ActorID,
+		ActorName:  entry.ActorName,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		Diff:       diffJSON,
+		IPAddress:  entry.IPAddress,
+		UserAgent:  entry.UserAgent,
+	}
+	if err := config.DB.Create(&event).Error; err != nil {
+		log.Printf("[Audit] 監査ログの保存に失敗: action=%s target=%s/%s err=%v", entry.Action, entry.TargetType, entry.TargetID, err)
+	}
+}
+
+// PurgeAuditEvents は保持期間を過ぎた監査ログを削除し、削除件数を返す。
+func PurgeAuditEvents(retention time.Duration) (int64, error) {
+	result := config.DB.Where("created_at < ?", time.Now().Add(-retention)).Delete(&models.AuditEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// StartAuditRetention は AUDIT_RETENTION_DAYS 日を過ぎた監査ログを1日1回削除する。
+// 0 以下を指定した場合は削除しない。
+func StartAuditRetention() {
+	days := config.GetEnvInt("AUDIT_RETENTION_DAYS", 365)
+	if days <= 0 {
+		log.Println("[Audit] AUDIT_RETENTION_DAYS が0以下のため、監査ログは無期限に保持します。")
+		return
+	}
+
+	go func() {
+		for {
+			n, err := PurgeAuditEvents(time.Duration(days) * 24 * time.Hour)
+			if err != nil {
+				log.Printf("[Audit] 古い監査ログの削除に失敗: %v", err)
+			} else if n > 0 {
+				log.Printf("[Audit] 保持期間(%d日)を過ぎた監査ログを削除しました: %d件", days, n)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
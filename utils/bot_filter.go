@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"bufio"
+	"k-cms/config"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ボット判定の理由（page_views / likes の bot_reason に記録する）
+const (
+	BotReasonUserAgent = "user_agent" // User-Agent がパターンリストに一致
+	BotReasonHeadless  = "headless"   // ヘッドレスブラウザ・自動操作の痕跡
+	BotReasonVelocity  = "velocity"   // 同じ fingerprint からの短時間の大量アクセス
+)
+
+// BOT_UA_PATTERNS_PATH が未設定の場合に使うパターン（大文字小文字を区別しない正規表現）
+var defaultBotUAPatterns = []string{
+	`bot\b`, `crawl`, `spider`, `slurp`, `archiver`, `facebookexternalhit`, `embedly`, `summaly`,
+	`curl/`, `wget/`, `python-requests`, `python-urllib`, `aiohttp`, `go-http-client`, `java/`, `okhttp`,
+	`axios/`, `node-fetch`, `undici`, `libwww-perl`, `httpclient`, `scrapy`, `lighthouse`, `pagespeed`,
+	`headlesschrome`, `phantomjs`,
+}
+
+// botPatternSet は User-Agent のパターンリスト。ファイルが更新されたら読み込み直す。
+type botPatternSet struct {
+	mu        sync.RWMutex
+	path      string
+	patterns  []*regexp.Regexp
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+var botPatterns = &botPatternSet{}
+
+// compileBotPatterns は1行1パターンのリストをコンパイルする（空行と # から始まる行は無視）。
+func compileBotPatterns(lines []string, source string) []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + line)
+		if err != nil {
+			log.Printf("[BotFilter] 無効なパターンを無視します: source=%s pattern=%q err=%v", source, line, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns
+}
+
+func readBotPatternFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// current はパターンリストを返す。BOT_UA_PATTERNS_PATH のファイルは1分ごとに更新日時を確認し、変わっていれば読み込み直す。
+// 読み込みに失敗した場合は直前のリスト（初回は組み込みのリスト）を使い続ける。
+func (s *botPatternSet) current() []*regexp.Regexp {
+	path := config.GetEnv("BOT_UA_PATTERNS_PATH", "")
+
+	s.mu.RLock()
+	fresh := s.patterns != nil && s.path == path && time.Since(s.checkedAt) < time.Minute
+	patterns := s.patterns
+	s.mu.RUnlock()
+	if fresh {
+		return patterns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkedAt = time.Now()
+
+	if path == "" {
+		if s.patterns == nil || s.path != "" {
+			s.patterns = compileBotPatterns(defaultBotUAPatterns, "default")
+			s.path = ""
+		}
+		return s.patterns
+	}
+
+	fi, err := os.Stat(path)
+	if err == nil && s.path == path && s.patterns != nil && fi.ModTime().Equal(s.modTime) {
+		return s.patterns
+	}
+	var lines []string
+	if err == nil {
+		lines, err = readBotPatternFile(path)
+	}
+	if err != nil {
+		log.Printf("[BotFilter] パターンファイルの読み込みに失敗: path=%s err=%v", path, err)
+		if s.patterns == nil {
+			s.patterns = compileBotPatterns(defaultBotUAPatterns, "default")
+		}
+		return s.patterns
+	}
+
+	s.patterns = compileBotPatterns(lines, path)
+	s.path = path
+	s.modTime = fi.ModTime()
+	log.Printf("[BotFilter] User-Agent のパターンを読み込みました: path=%s patterns=%d", path, len(s.patterns))
+	return s.patterns
+}
+
+// velocityTracker は fingerprint ごとの直近のアクセス時刻を保持する。
+type velocityTracker struct {
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	cleanedAt time.Time
+}
+
+var botVelocity = &velocityTracker{hits: map[string][]time.Time{}}
+
+// record はアクセスを記録し、window 内のアクセス数を返す（limit+1 件までしか保持しないため、それ以上は limit+1 を返す）。
+func (v *velocityTracker) record(key string, window time.Duration, limit int) int {
+	now := time.Now()
+	cutoff := now.Add(-window)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	hits := v.hits[key]
+	i := 0
+	for i < len(hits) && hits[i].Before(cutoff) {
+		i++
+	}
+	hits = append(hits[i:], now)
+	if len(hits) > limit+1 {
+		hits = hits[len(hits)-(limit+1):]
+	}
+	v.hits[key] = hits
+
+	// 古い fingerprint を定期的に掃除してメモリを使い続けないようにする
+	if now.Sub(v.cleanedAt) > window {
+		for k, h := range v.hits {
+			if len(h) == 0 || h[len(h)-1].Before(cutoff) {
+				delete(v.hits, k)
+			}
+		}
+		v.cleanedAt = now
+	}
+	return len(hits)
+}
+
+// forget は fingerprint の記録を削除する（データ削除の依頼に応じる場合）。
+func (v *velocityTracker) forget(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.hits, key)
+}
+
+// DetectBot はページビュー・いいねのリクエストがボットらしいかを判定し、理由を返す（人間と判断した場合は空文字）。
+// webdriver にはクライアントが送った navigator.webdriver の値を渡す。
+// BOT_VELOCITY_MAX（デフォルト30）回を超えるアクセスが BOT_VELOCITY_WINDOW（デフォルト1分）内に同じ fingerprint からあれば velocity とする。
+func DetectBot(r *http.Request, fingerprint string, webdriver bool) string {
+	// アクセス数は判定結果に関わらず数える（ボットの判定後も連続アクセスを検出し続けるため）
+	window := config.GetEnvDuration("BOT_VELOCITY_WINDOW", time.Minute)
+	limit := config.GetEnvInt("BOT_VELOCITY_MAX", 30)
+	hits := botVelocity.record(fingerprint, window, limit)
+
+	ua := r.UserAgent()
+	for _, re := range botPatterns.current() {
+		if re.MatchString(ua) {
+			return BotReasonUserAgent
+		}
+	}
+
+	// ヘッドレスブラウザの痕跡: UA が無い、自動操作フラグ、通常のブラウザが必ず送るヘッダーが無い
+	if ua == "" || webdriver ||
+		strings.Contains(r.Header.Get("Sec-CH-UA"), "HeadlessChrome") ||
+		r.Header.Get("Accept-Language") == "" {
+		return BotReasonHeadless
+	}
+
+	if hits > limit {
+		return BotReasonVelocity
+	}
+	return ""
+}
@@ -3,90 +3,292 @@ package utils
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 const (
-	// ビルドのタイムアウト時間（5分）
+	// ターゲットのデフォルトのタイムアウト時間（5分）
 	buildTimeout = 5 * time.Minute
 )
 
-// TriggerBuild はフロントエンドビルドを非同期で実行します
-// action: "create", "update", "delete"のいずれか
-// articleID: 対象の記事ID
-func TriggerBuild(action, articleID string) {
-	scriptPath := os.Getenv("BUILD_SCRIPT_PATH")
-	if scriptPath == "" {
-		log.Println("[Build] BUILD_SCRIPT_PATH環境変数が設定されていません。ビルドをスキップします。")
-		return
+// ErrBuildNotConfigured はビルドパイプラインにターゲットが無くビルドできないことを表す
+var ErrBuildNotConfigured = errors.New("build pipeline is not configured")
+
+// TriggerBuild はフロントエンドビルドをキューに積み、まとめられた先のジョブを返します
+// req.Action: "create", "update", "delete"など
+// req.ArticleID: 対象の記事ID
+// 実行待ちのビルドがあればそのジョブにまとめられ、1回のビルドで処理されます
+func TriggerBuild(req BuildRequest) (BuildJob, error) {
+	if len(getBuildPipeline().Targets) == 0 {
+		log.Println("[Build] BUILD_PIPELINE_CONFIG / BUILD_SCRIPT_PATH環境変数が設定されていません。ビルドをスキップします。")
+		return BuildJob{}, ErrBuildNotConfigured
 	}
 
-	log.Printf("[Build] ビルドリクエストを受信: action=%s, articleID=%s", action, articleID)
+	// キューに積むだけなのでAPIレスポンスをブロックしない
+	job := getBuildQueue().enqueue(req)
+	log.Printf("[Build] ビルドリクエストを受信: source=%s, action=%s, articleID=%s, job=%s (対象記事 %d件)", req.Source, req.Action, req.ArticleID, job.ID, len(job.ArticleIDs))
+	return job, nil
+}
 
-	// 非同期でビルドを実行（APIレスポンスをブロックしない）
-	go executeBuild(scriptPath, action, articleID)
+// targetResult はターゲット1つ分の実行結果
+type targetResult struct {
+	name     string
+	state    BuildState
+	exitCode int
+	err      string
 }
 
-// executeBuild はビルドスクリプトを実際に実行します
-func executeBuild(scriptPath, action, articleID string) {
+// executeBuild はジョブのアクションに該当するパイプラインのターゲットを実行します
+// 直列実行では失敗したターゲット以降をスキップし、並列実行では全ターゲットの終了を待ちます
+func executeBuild(job *BuildJob) {
+	pipeline := getBuildPipeline()
+	action := job.Action
+	articleID := strings.Join(job.ArticleIDs, ",")
+
 	// ステータス更新: 開始
-	SetBuildStart(action, articleID)
+	SetBuildStart(job)
 
 	startTime := time.Now()
-	log.Printf("[Build] ビルドプロセスを開始: action=%s, articleID=%s", action, articleID)
-	AppendBuildLog(fmt.Sprintf("Build started: action=%s, articleID=%s", action, articleID))
+	log.Printf("[Build] ビルドプロセスを開始: job=%s, action=%s, articleID=%s", job.ID, action, articleID)
+	AppendBuildLog(job.ID, fmt.Sprintf("Build started: action=%s, articleID=%s", action, articleID))
+
+	// CancelBuild から中断できるよう登録する（終了を記録する setBuildFinished で登録を解除する）
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	registerRunningBuild(job.ID, cancel)
+
+	targets := pipeline.targetsFor(job.Actions)
+	if len(targets) == 0 {
+		AppendBuildLog(job.ID, "No pipeline targets matched; nothing to do")
+		SetBuildComplete(job.ID, true, 0, "")
+		return
+	}
+	createBuildTargetRecords(job.ID, targets)
+	for _, t := range targets {
+		setTargetState(job.ID, t.Name, string(BuildStateQueued), nil)
+	}
+
+	var results []targetResult
+	if pipeline.Mode == PipelineModeParallel {
+		results = runTargetsParallel(ctx, job, targets)
+	} else {
+		results = runTargetsSequential(ctx, job, targets)
+	}
+
+	duration := time.Since(startTime)
+
+	// キャンセルされた場合
+	if errors.Is(context.Cause(ctx), errBuildCanceled) {
+		exitCode := -1
+		for _, r := range results {
+			if r.state == BuildStateCanceled {
+				exitCode = r.exitCode
+				break
+			}
+		}
+		log.Printf("[Build] ⏹ ビルドをキャンセルしました: job=%s, action=%s, articleID=%s, 所要時間=%v", job.ID, action, articleID, duration)
+
+		AppendBuildLog(job.ID, "Build canceled")
+		SetBuildCanceled(job.ID, exitCode)
+		return
+	}
+
+	// エラーハンドリング（最初に失敗したターゲットの終了コードをビルドの終了コードとする）
+	var failures []string
+	exitCode := 0
+	for _, r := range results {
+		if r.state != BuildStateFailed {
+			continue
+		}
+		if len(failures) == 0 {
+			exitCode = r.exitCode
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", r.name, r.err))
+	}
+	if len(failures) > 0 {
+		errorMsg := strings.Join(failures, "; ")
+		logBuildFailure(action, articleID, duration, errorMsg, "See build logs for details")
+
+		AppendBuildLog(job.ID, fmt.Sprintf("Build failed: %s", errorMsg))
+		SetBuildComplete(job.ID, false, exitCode, errorMsg)
+		return
+	}
 
-	// タイムアウト付きコンテキストを作成
-	ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+	// 成功
+	log.Printf("[Build] ✅ ビルド成功: action=%s, articleID=%s, 所要時間=%v", action, articleID, duration)
+
+	AppendBuildLog(job.ID, fmt.Sprintf("Build success! Duration: %v", duration))
+	SetBuildComplete(job.ID, true, 0, "")
+}
+
+func runTargetsSequential(ctx context.Context, job *BuildJob, targets []PipelineTarget) []targetResult {
+	results := make([]targetResult, 0, len(targets))
+	for i, t := range targets {
+		r := runTarget(ctx, job, t)
+		results = append(results, r)
+		if r.state == BuildStateSuccess {
+			continue
+		}
+
+		// 失敗・キャンセル時は残りのターゲットをスキップする
+		for _, rest := range targets[i+1:] {
+			setTargetState(job.ID, rest.Name, string(BuildStateSkipped), nil)
+			results = append(results, targetResult{name: rest.Name, state: BuildStateSkipped, exitCode: -1})
+		}
+		break
+	}
+	return results
+}
+
+func runTargetsParallel(ctx context.Context, job *BuildJob, targets []PipelineTarget) []targetResult {
+	results := make([]targetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t PipelineTarget) {
+			defer wg.Done()
+			results[i] = runTarget(ctx, job, t)
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+// runTarget はターゲットのコマンドを実行し、出力をビルドログに追加します
+// コマンドには BUILD_JOB_ID / BUILD_ACTIONS / BUILD_ARTICLE_IDS / BUILD_TARGET / BUILD_RELEASE_DIR / BUILD_PREVIEW_TOKENS 環境変数でジョブの内容を渡します
+func runTarget(buildCtx context.Context, job *BuildJob, target PipelineTarget) targetResult {
+	result := targetResult{name: target.Name, state: BuildStateFailed, exitCode: -1}
+	articleID := strings.Join(job.ArticleIDs, ",")
+	startTime := time.Now()
+
+	if buildCtx.Err() != nil {
+		result.state = BuildStateCanceled
+		setTargetState(job.ID, target.Name, string(BuildStateCanceled), nil)
+		return result
+	}
+
+	setTargetState(job.ID, target.Name, string(BuildStateRunning), map[string]interface{}{"started_at": startTime})
+	if target.Type == PipelineTypeStatic {
+		appendTargetLog(job.ID, target.Name, "Target started: static export")
+	} else {
+		appendTargetLog(job.ID, target.Name, fmt.Sprintf("Target started: %s", target.Command))
+	}
+
+	// 終了時に結果を記録する
+	defer func() {
+		updates := map[string]interface{}{"finished_at": time.Now(), "error": result.err}
+		if result.exitCode >= 0 {
+			updates["exit_code"] = result.exitCode
+		}
+		setTargetState(job.ID, target.Name, string(result.state), updates)
+		appendTargetLog(job.ID, target.Name, fmt.Sprintf("Target %s: duration=%v", result.state, time.Since(startTime)))
+	}()
+
+	// アトミックデプロイ: コマンドは新しいリリースディレクトリに出力し、成功したら公開する
+	releaseDir := ""
+	if target.Deploy != nil {
+		dir, err := prepareRelease(job.ID, target.Deploy)
+		if err != nil {
+			result.err = "Failed to create release directory: " + err.Error()
+			return result
+		}
+		releaseDir = dir
+		appendTargetLog(job.ID, target.Name, "Release directory: "+releaseDir)
+		defer func() {
+			if result.state != BuildStateSuccess {
+				discardRelease(releaseDir)
+				return
+			}
+			if _, err := activateRelease(job.ID, target.Name, target.Deploy, releaseDir); err != nil {
+				result.state = BuildStateFailed
+				result.err = "Failed to activate release: " + err.Error()
+				discardRelease(releaseDir)
+				return
+			}
+			appendTargetLog(job.ID, target.Name, "Release activated: "+releaseDir)
+		}()
+	}
+
+	// ターゲットごとのタイムアウト
+	ctx, cancel := context.WithTimeout(buildCtx, target.timeout)
 	defer cancel()
 
-	// ビルドスクリプトを実行
-	cmd := exec.CommandContext(ctx, "/bin/bash", scriptPath, action, articleID)
+	if target.Type == PipelineTypeStatic {
+		runStaticTarget(ctx, buildCtx, job, target, releaseDir, &result)
+		return result
+	}
+
+	placeholders := strings.NewReplacer(
+		"{build_id}", job.ID,
+		"{action}", job.Action,
+		"{actions}", strings.Join(job.Actions, ","),
+		"{article_ids}", articleID,
+		"{release_dir}", releaseDir,
+	)
+	args := make([]string, len(target.Args))
+	for i, a := range target.Args {
+		args[i] = placeholders.Replace(a)
+	}
+
+	// npm などの子プロセスもまとめて止められるよう、独立したプロセスグループで起動する
+	cmd := exec.CommandContext(ctx, target.Command, args...)
+	cmd.Dir = target.Dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	group := &buildProcessGroup{cmd: cmd}
+	cmd.Cancel = group.terminate
+	cmd.Env = append(os.Environ(),
+		"BUILD_JOB_ID="+job.ID,
+		"BUILD_ACTIONS="+strings.Join(job.Actions, ","),
+		"BUILD_ARTICLE_IDS="+articleID,
+		"BUILD_TARGET="+target.Name,
+		"BUILD_RELEASE_DIR="+releaseDir,
+		"BUILD_PREVIEW_TOKENS="+strings.Join(job.PreviewTokens, ","),
+	)
+	// 追加の環境変数は名前順に設定する（同名の変数は後勝ちで上書きされる）
+	keys := make([]string, 0, len(target.Env))
+	for k := range target.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+target.Env[k])
+	}
 
 	// パイプの取得
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		logErrorAndFinish(action, articleID, startTime, "Failed to get stdout pipe: "+err.Error(), "")
-		return
+		result.err = "Failed to get stdout pipe: " + err.Error()
+		return result
 	}
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
-		logErrorAndFinish(action, articleID, startTime, "Failed to get stderr pipe: "+err.Error(), "")
-		return
+		result.err = "Failed to get stderr pipe: " + err.Error()
+		return result
 	}
 
 	// コマンド開始
 	if err := cmd.Start(); err != nil {
-		logErrorAndFinish(action, articleID, startTime, "Failed to start command: "+err.Error(), "")
-		return
+		result.err = "Failed to start command: " + err.Error()
+		return result
 	}
 
 	// バッファ付きチャネルでブロッキングを防止
 	logChan := make(chan string, 512)
 	doneChan := make(chan struct{}, 2)
 
-	// Stdout読み取りゴルーチン
-	go func() {
-		defer func() { doneChan <- struct{}{} }()
-		scanner := bufio.NewScanner(stdoutPipe)
-		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
-		for scanner.Scan() {
-			select {
-			case logChan <- scanner.Text():
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-
-	// Stderr読み取りゴルーチン
-	go func() {
+	readPipe := func(pipe interface{ Read([]byte) (int, error) }) {
 		defer func() { doneChan <- struct{}{} }()
-		scanner := bufio.NewScanner(stderrPipe)
+		scanner := bufio.NewScanner(pipe)
 		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 		for scanner.Scan() {
 			select {
@@ -95,7 +297,10 @@ func executeBuild(scriptPath, action, articleID string) {
 				return
 			}
 		}
-	}()
+	}
+	// Stdout / Stderr 読み取りゴルーチン
+	go readPipe(stdoutPipe)
+	go readPipe(stderrPipe)
 
 	// 両リーダー完了後にチャネルをクローズするゴルーチン
 	go func() {
@@ -106,46 +311,81 @@ func executeBuild(scriptPath, action, articleID string) {
 
 	// ログをドレインしてから Wait（logChan が close されるまでブロック）
 	for text := range logChan {
-		AppendBuildLog(text)
+		appendTargetLog(job.ID, target.Name, text)
 	}
 
 	// コマンド完了待ち
 	waitErr := cmd.Wait()
+	group.markReaped()
+	if exitError, ok := waitErr.(*exec.ExitError); ok {
+		result.exitCode = exitError.ExitCode()
+	}
 
-	duration := time.Since(startTime)
+	switch {
+	case waitErr == nil:
+		result.state = BuildStateSuccess
+		result.exitCode = 0
+	case errors.Is(context.Cause(buildCtx), errBuildCanceled):
+		result.state = BuildStateCanceled
+		result.err = "canceled"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.err = fmt.Sprintf("タイムアウト (%v)", target.timeout)
+	case result.exitCode >= 0:
+		result.err = fmt.Sprintf("Exit code: %d, Error: %s", result.exitCode, waitErr.Error())
+	default:
+		result.err = waitErr.Error()
+	}
+	return result
+}
+
+// 記事単位の変更のみを含むジョブは、静的出力で関係するページだけを出力し直す
+var incrementalActions = []string{"create", "update", "delete"}
+
+// runStaticTarget は組み込みの静的サイト出力を実行します
+func runStaticTarget(ctx, buildCtx context.Context, job *BuildJob, target PipelineTarget, releaseDir string, result *targetResult) {
+	outputDir := target.Static.Output
+	if releaseDir != "" {
+		outputDir = releaseDir
+	}
 
-	// エラーハンドリング
-	if waitErr != nil {
-		errorMsg := waitErr.Error()
-		if ctx.Err() == context.DeadlineExceeded {
-			errorMsg = "タイムアウト"
-		} else if exitError, ok := waitErr.(*exec.ExitError); ok {
-			// 終了コードが含まれる場合
-			errorMsg = fmt.Sprintf("Exit code: %d, Error: %s", exitError.ExitCode(), exitError.Error())
+	incremental := len(job.ArticleIDs) > 0
+	for _, a := range job.Actions {
+		// コメントは静的出力に含まれないため、まとめられていても出力し直す範囲は変わらない
+		if a != BuildActionComment && !containsString(incrementalActions, a) {
+			incremental = false
+		}
+	}
+	// リリースディレクトリは空なので、差分出力する場合は公開中のリリースを引き継ぐ
+	if incremental && releaseDir != "" {
+		current, err := filepath.EvalSymlinks(filepath.Join(target.Deploy.Root, deployCurrentLink))
+		if err != nil {
+			incremental = false
+		} else if err := copyDir(current, releaseDir); err != nil {
+			appendTargetLog(job.ID, target.Name, "Failed to copy current release; rendering all pages: "+err.Error())
+			incremental = false
 		}
-		
-		logBuildFailure(action, articleID, duration, errorMsg, "See build logs for details")
-		sendBuildFailureNotification(action, articleID, errorMsg, "See build logs for details")
-		
-		AppendBuildLog(fmt.Sprintf("Build failed: %s", errorMsg))
-		SetBuildComplete(false)
-		return
 	}
 
-	// 成功
-	log.Printf("[Build] ✅ ビルド成功: action=%s, articleID=%s, 所要時間=%v", action, articleID, duration)
-	
-	AppendBuildLog(fmt.Sprintf("Build success! Duration: %v", duration))
-	SetBuildComplete(true)
-}
+	err := ExportStaticSite(ctx, StaticExportOptions{
+		OutputDir:   outputDir,
+		Config:      *target.Static,
+		ArticleIDs:  job.ArticleIDs,
+		Incremental: incremental,
+		Log:         func(line string) { appendTargetLog(job.ID, target.Name, line) },
+	})
 
-// エラー終了時のヘルパー関数
-func logErrorAndFinish(action, articleID string, startTime time.Time, errorMsg string, output string) {
-	duration := time.Since(startTime)
-	logBuildFailure(action, articleID, duration, errorMsg, output)
-	sendBuildFailureNotification(action, articleID, errorMsg, output)
-	AppendBuildLog(fmt.Sprintf("Build failed: %s", errorMsg))
-	SetBuildComplete(false)
+	switch {
+	case err == nil:
+		result.state = BuildStateSuccess
+		result.exitCode = 0
+	case errors.Is(context.Cause(buildCtx), errBuildCanceled):
+		result.state = BuildStateCanceled
+		result.err = "canceled"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.err = fmt.Sprintf("タイムアウト (%v)", target.timeout)
+	default:
+		result.err = err.Error()
+	}
 }
 
 // logBuildFailure はビルド失敗時の詳細ログを出力します
@@ -154,39 +394,3 @@ func logBuildFailure(action, articleID string, duration time.Duration, errorMsg
 	log.Printf("[Build] エラー: %s", errorMsg)
 	log.Printf("[Build] 出力:\n%s", output)
 }
-
-// sendBuildFailureNotification はビルド失敗時の通知を送信します
-// 現在はログ出力のみですが、将来的にメールやSlack通知を追加できます
-func sendBuildFailureNotification(action, articleID, errorMsg string, output string) {
-	// 通知メッセージを構築
-	notificationMsg := fmt.Sprintf(
-		"🚨 フロントエンドビルド失敗通知\n"+
-			"アクション: %s\n"+
-			"記事ID: %s\n"+
-			"エラー: %s\n"+
-			"出力:\n%s",
-		action, articleID, errorMsg, output,
-	)
-
-	// 現在はログに出力（将来的に拡張可能）
-	log.Printf("[Build Notification] %s", notificationMsg)
-
-	// TODO: 外部通知サービスとの連携
-	// 例：
-	// - メール送信
-	// - Slack Webhook
-	// - Discord Webhook
-	// - データベースに失敗履歴を保存
-
-	// Slack Webhook の実装例（コメントアウト）
-	/*
-		slackWebhookURL := os.Getenv("SLACK_WEBHOOK_URL")
-		if slackWebhookURL != "" {
-			payload := map[string]interface{}{
-				"text": notificationMsg,
-			}
-			jsonData, _ := json.Marshal(payload)
-			http.Post(slackWebhookURL, "application/json", bytes.NewBuffer(jsonData))
-		}
-	*/
-}
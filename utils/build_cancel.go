@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	// ErrBuildNotCancelable は終了済みなどでキャンセルできないビルドを表す
+	ErrBuildNotCancelable = errors.New("build is not cancelable")
+
+	errBuildCanceled = errors.New("build canceled")
+)
+
+// 実行中のビルドのキャンセル関数（ジョブIDごと）
+var (
+	runningBuilds   = map[string]context.CancelCauseFunc{}
+	runningBuildsMu sync.Mutex
+)
+
+func registerRunningBuild(jobID string, cancel context.CancelCauseFunc) {
+	runningBuildsMu.Lock()
+	defer runningBuildsMu.Unlock()
+	runningBuilds[jobID] = cancel
+}
+
+func unregisterRunningBuild(jobID string) {
+	runningBuildsMu.Lock()
+	defer runningBuildsMu.Unlock()
+	delete(runningBuilds, jobID)
+}
+
+// buildCancelGrace は SIGTERM を送ってから SIGKILL するまでの猶予
+// BUILD_CANCEL_GRACE（デフォルト10秒）
+func buildCancelGrace() time.Duration {
+	return config.GetEnvDuration("BUILD_CANCEL_GRACE", 10*time.Second)
+}
+
+// buildProcessGroup はビルドのスクリプトを起動したプロセスグループ。
+// Wait でプロセスを回収した後は pgid が別のプロセスに再利用されうるため、シグナルを送らない。
+type buildProcessGroup struct {
+	cmd    *exec.Cmd // Setpgid で起動するため、プロセスの PID がそのまま pgid になる
+	mu     sync.Mutex
+	timer  *time.Timer
+	reaped bool
+}
+
+// terminate はプロセスグループ全体に SIGTERM を送り、猶予期間を過ぎても回収されていなければ SIGKILL する。
+func (g *buildProcessGroup) terminate() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.reaped {
+		return nil
+	}
+
+	grace := buildCancelGrace()
+	log.Printf("[Build] プロセスグループに SIGTERM を送信: pgid=%d (猶予 %v)", g.cmd.Process.Pid, grace)
+	if err := syscall.Kill(-g.cmd.Process.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	g.timer = time.AfterFunc(grace, g.kill)
+	return nil
+}
+
+func (g *buildProcessGroup) kill() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.reaped {
+		return
+	}
+	// 全プロセスが終了していれば ESRCH になる
+	if err := syscall.Kill(-g.cmd.Process.Pid, syscall.SIGKILL); err == nil {
+		log.Printf("[Build] 猶予期間を過ぎても終了しなかったため SIGKILL を送信: pgid=%d", g.cmd.Process.Pid)
+	}
+}
+
+// markReaped は cmd.Wait が返った後に呼び、以降のシグナルの送信を止める。
+func (g *buildProcessGroup) markReaped() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reaped = true
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+}
+
+// CancelBuild はビルドをキャンセルする。
+// 実行待ちであればキューから取り除き、実行中であればスクリプトのプロセスグループを停止する。
+// canceledBy にはキャンセルを要求したユーザーを記録する。
+func CancelBuild(buildID string, canceledBy *uuid.UUID) error {
+	var build models.Build
+	if err := config.DB.Select("id", "state").Where("id = ?", buildID).First(&build).Error; err != nil {
+		return err
+	}
+	if build.State != models.BuildStateQueued && build.State != models.BuildStateRunning {
+		return ErrBuildNotCancelable
+	}
+
+	if getBuildQueue().cancelQueued(buildID) {
+		log.Printf("[Build] ⏹ 実行待ちのビルドをキャンセルしました: job=%s", buildID)
+		recordBuildCanceledBy(buildID, canceledBy)
+		markBuildFinished(buildID, BuildStateCanceled, time.Now(), -1, "canceled")
+		return nil
+	}
+
+	// 登録の解除（終了処理の開始）と排他するため、キャンセルの記録までロックを保持する
+	runningBuildsMu.Lock()
+	defer runningBuildsMu.Unlock()
+	cancel, ok := runningBuilds[buildID]
+	if !ok {
+		// キューから取り出された直後、またはすでに終了している
+		return ErrBuildNotCancelable
+	}
+	if err := config.DB.Select("id", "state").Where("id = ?", buildID).First(&build).Error; err != nil {
+		return err
+	}
+	if build.State != models.BuildStateRunning {
+		return ErrBuildNotCancelable
+	}
+
+	log.Printf("[Build] 実行中のビルドのキャンセルを要求: job=%s", buildID)
+	recordBuildCanceledBy(buildID, canceledBy)
+	AppendBuildLog(buildID, "Cancel requested")
+	cancel(errBuildCanceled)
+	return nil
+}
+
+func recordBuildCanceledBy(buildID string, canceledBy *uuid.UUID) {
+	if err := config.DB.Model(&models.Build{}).Where("id = ?", buildID).
+		Update("canceled_by", canceledBy).Error; err != nil {
+		log.Printf("[Build] ビルド履歴の更新に失敗: job=%s err=%v", buildID, err)
+	}
+}
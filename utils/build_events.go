@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"sync"
+	"time"
+)
+
+// BuildEvent の種類
+const (
+	BuildEventLog    = "log"
+	BuildEventState  = "state"
+	BuildEventTarget = "target" // パイプラインのターゲットの状態遷移
+)
+
+// BuildEvent はビルドのログ1行、または状態遷移（queued, running, success, failed, canceled）を表す。
+// Seq はログ行の連番。状態イベントにはその時点までに出力された最後の連番を入れる。
+// Target はログを出力した、または状態が変わったパイプラインのターゲット名。
+type BuildEvent struct {
+	BuildID string    `json:"build_id"`
+	Type    string    `json:"type"`
+	Seq     int       `json:"seq"`
+	Target  string    `json:"target,omitempty"`
+	Line    string    `json:"line,omitempty"`
+	State   string    `json:"state,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// 購読者ごとのバッファ。溢れた購読者は切断し、Last-Event-ID での再接続に任せる
+const buildSubscriberBuffer = 256
+
+// BuildSubscription はビルド1件のイベント購読
+type BuildSubscription struct {
+	C       chan BuildEvent
+	buildID string
+	closed  bool
+}
+
+type buildEventHub struct {
+	mu     sync.Mutex
+	subs   map[string]map[*BuildSubscription]struct{}
+	closed bool // サーバーの終了時に closeAll した後は購読を受け付けない
+}
+
+var buildEvents = &buildEventHub{subs: map[string]map[*BuildSubscription]struct{}{}}
+
+func (h *buildEventHub) subscribe(buildID string) *BuildSubscription {
+	sub := &BuildSubscription{C: make(chan BuildEvent, buildSubscriberBuffer), buildID: buildID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		// 閉じたチャネルを返し、配信中のストリームと同じく直ちに終了させる
+		sub.closed = true
+		close(sub.C)
+		return sub
+	}
+	if h.subs[buildID] == nil {
+		h.subs[buildID] = map[*BuildSubscription]struct{}{}
+	}
+	h.subs[buildID][sub] = struct{}{}
+	return sub
+}
+
+// removeLocked は購読を解除してチャネルを閉じる（h.mu を保持した状態で呼ぶ）。
+func (h *buildEventHub) removeLocked(sub *BuildSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.C)
+	delete(h.subs[sub.buildID], sub)
+	if len(h.subs[sub.buildID]) == 0 {
+		delete(h.subs, sub.buildID)
+	}
+}
+
+// closeAll は全ての購読を解除してチャネルを閉じ、以降の購読も受け付けない。
+func (h *buildEventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.removeLocked(sub)
+		}
+	}
+}
+
+// publish はイベントを購読者に配信する。ビルド処理をブロックしないよう送信は非同期的に行い、
+// 受信が追いつかない購読者は切断する。
+func (h *buildEventHub) publish(ev BuildEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[ev.BuildID] {
+		select {
+		case sub.C <- ev:
+		default:
+			h.removeLocked(sub)
+		}
+	}
+}
+
+// Close は購読を解除する。
+func (s *BuildSubscription) Close() {
+	buildEvents.mu.Lock()
+	defer buildEvents.mu.Unlock()
+	buildEvents.removeLocked(s)
+}
+
+func publishBuildState(jobID string, state string, seq int) {
+	buildEvents.publish(BuildEvent{
+		BuildID: jobID,
+		Type:    BuildEventState,
+		Seq:     seq,
+		State:   state,
+		Time:    time.Now(),
+	})
+}
+
+// SubscribeBuildEvents はビルドのイベントを購読し、afterSeq より後のログ行を返す。
+// 購読開始と既存ログの取得を書き出し処理と排他して行うため、返したログとチャネルに届くイベントの間に欠落は無い
+// （重複はありうるので、呼び出し側は Seq で読み飛ばすこと）。
+func SubscribeBuildEvents(buildID string, afterSeq int) (*BuildSubscription, []models.BuildLogLine, error) {
+	buildLogs.flushMu.RLock()
+	defer buildLogs.flushMu.RUnlock()
+
+	sub := buildEvents.subscribe(buildID)
+
+	var lines []models.BuildLogLine
+	if err := config.DB.Where("build_id = ? AND seq > ?", buildID, afterSeq).
+		Order("seq asc").Find(&lines).Error; err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+
+	// まだDBに書き出されていない行を追加する
+	last := afterSeq
+	if len(lines) > 0 {
+		last = lines[len(lines)-1].Seq
+	}
+	lines = append(lines, buildLogs.pendingAfter(buildID, last)...)
+
+	return sub, lines, nil
+}
@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// ビルドログをDBへ書き出す間隔と、1回あたりの最大行数
+	buildLogFlushInterval = time.Second
+	buildLogFlushBatch    = 200
+)
+
+// createBuildRecord はキューに積まれたジョブの履歴レコードを作成する。
+func createBuildRecord(job *BuildJob) {
+	build := models.Build{
+		ID:            uuid.FromStringOrNil(job.ID),
+		TriggerSource: job.Source,
+		Action:        job.Action,
+		Actions:       models.StringArray(job.Actions),
+		ArticleIDs:    models.StringArray(job.ArticleIDs),
+		UserID:        job.UserID,
+		RetryOf:       job.RetryOf,
+		State:         models.BuildStateQueued,
+		QueuedAt:      job.QueuedAt,
+	}
+	if err := config.DB.Create(&build).Error; err != nil {
+		log.Printf("[Build] ビルド履歴の作成に失敗: job=%s err=%v", job.ID, err)
+	}
+	publishBuildState(job.ID, models.BuildStateQueued, 0)
+}
+
+// updateQueuedBuildRecord は実行待ちのジョブに要求がまとめられた際に履歴を更新する。
+func updateQueuedBuildRecord(job *BuildJob) {
+	if err := config.DB.Model(&models.Build{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"trigger_source": job.Source,
+		"action":         job.Action,
+		"actions":        models.StringArray(job.Actions),
+		"article_ids":    models.StringArray(job.ArticleIDs),
+	}).Error; err != nil {
+		log.Printf("[Build] ビルド履歴の更新に失敗: job=%s err=%v", job.ID, err)
+	}
+}
+
+// markBuildStarted は履歴を実行中に更新する。
+func markBuildStarted(jobID string, startedAt time.Time) {
+	if err := config.DB.Model(&models.Build{}).Where("id = ?", jobID).Updates(map[string]interface{}{
+		"state":      models.BuildStateRunning,
+		"started_at": startedAt,
+	}).Error; err != nil {
+		log.Printf("[Build] ビルド履歴の更新に失敗: job=%s err=%v", jobID, err)
+	}
+	publishBuildState(jobID, models.BuildStateRunning, buildLogs.lastSeq(jobID))
+}
+
+// markBuildFinished は残りのログを書き出し、履歴に結果を記録する。
+// exitCode が負の場合はプロセスの終了コードが得られなかったものとして NULL を記録する。
+func markBuildFinished(jobID string, state BuildState, finishedAt time.Time, exitCode int, errorMsg string) {
+	lastSeq := buildLogs.lastSeq(jobID)
+	buildLogs.flush(jobID, true)
+
+	updates := map[string]interface{}{
+		"state":       string(state),
+		"finished_at": finishedAt,
+		"error":       errorMsg,
+	}
+	if exitCode >= 0 {
+		updates["exit_code"] = exitCode
+	}
+	if err := config.DB.Model(&models.Build{}).Where("id = ?", jobID).Updates(updates).Error; err != nil {
+		log.Printf("[Build] ビルド履歴の更新に失敗: job=%s err=%v", jobID, err)
+	}
+	publishBuildState(jobID, string(state), lastSeq)
+	notifyBuildFinished(jobID, state)
+}
+
+// createBuildTargetRecords は実行するターゲットの結果レコードを実行待ちとして作成する。
+func createBuildTargetRecords(jobID string, targets []PipelineTarget) {
+	records := make([]models.BuildTarget, 0, len(targets))
+	for _, t := range targets {
+		records = append(records, models.BuildTarget{
+			BuildID: uuid.FromStringOrNil(jobID),
+			Name:    t.Name,
+			State:   models.BuildStateQueued,
+		})
+	}
+	if len(records) == 0 {
+		return
+	}
+	if err := config.DB.Create(&records).Error; err != nil {
+		log.Printf("[Build] ターゲットの記録に失敗: job=%s err=%v", jobID, err)
+	}
+}
+
+// updateBuildTargetRecord はターゲットの状態を更新し、購読者に通知する。
+func updateBuildTargetRecord(jobID, target string, state string, updates map[string]interface{}) {
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["state"] = state
+	if err := config.DB.Model(&models.BuildTarget{}).Where("build_id = ? AND name = ?", jobID, target).
+		Updates(updates).Error; err != nil {
+		log.Printf("[Build] ターゲットの更新に失敗: job=%s target=%s err=%v", jobID, target, err)
+	}
+	buildEvents.publish(BuildEvent{
+		BuildID: jobID,
+		Type:    BuildEventTarget,
+		Seq:     buildLogs.lastSeq(jobID),
+		Target:  target,
+		State:   state,
+		Time:    time.Now(),
+	})
+}
+
+// buildLogWriter はビルドログを溜めておき、一定間隔でまとめてDBへ書き出す。
+// 1行ごとに INSERT するとビルド出力の多いときにDB負荷が高くなるため。
+type buildLogWriter struct {
+	mu      sync.Mutex
+	seq     map[string]int
+	pending map[string][]models.BuildLogLine
+	started bool
+
+	// flushMu は書き出し中の行が「書き出し待ちにもDBにも見えない」瞬間を
+	// SubscribeBuildEvents から観測されないようにするためのロック
+	flushMu sync.RWMutex
+}
+
+var buildLogs = &buildLogWriter{
+	seq:     map[string]int{},
+	pending: map[string][]models.BuildLogLine{},
+}
+
+// append はログ行を書き出し待ちに追加し、割り当てた連番を返す。
+// target はログを出力したパイプラインのターゲット（ビルド全体のログは空）。
+func (w *buildLogWriter) append(jobID, target, line string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.started {
+		w.started = true
+		go w.loop()
+	}
+
+	w.seq[jobID]++
+	seq := w.seq[jobID]
+	now := time.Now()
+	w.pending[jobID] = append(w.pending[jobID], models.BuildLogLine{
+		BuildID:   uuid.FromStringOrNil(jobID),
+		Seq:       seq,
+		Target:    target,
+		Line:      line,
+		CreatedAt: now,
+	})
+
+	// 連番の順に配信されるよう w.mu を保持したまま配信する（publish はブロックしない）
+	buildEvents.publish(BuildEvent{BuildID: jobID, Type: BuildEventLog, Seq: seq, Target: target, Line: line, Time: now})
+	return seq
+}
+
+// lastSeq はビルドで最後に割り当てた連番を返す。
+func (w *buildLogWriter) lastSeq(jobID string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq[jobID]
+}
+
+// pendingAfter はまだDBに書き出されていない行のうち、連番が afterSeq より後のものを返す。
+func (w *buildLogWriter) pendingAfter(jobID string, afterSeq int) []models.BuildLogLine {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var lines []models.BuildLogLine
+	for _, l := range w.pending[jobID] {
+		if l.Seq > afterSeq {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// flush は書き出し待ちのログをDBに保存する。final の場合は連番の管理も終了する。
+func (w *buildLogWriter) flush(jobID string, final bool) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	lines := w.pending[jobID]
+	delete(w.pending, jobID)
+	if final {
+		delete(w.seq, jobID)
+	}
+	w.mu.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+	if err := config.DB.CreateInBatches(lines, buildLogFlushBatch).Error; err != nil {
+		log.Printf("[Build] ビルドログの保存に失敗: job=%s lines=%d err=%v", jobID, len(lines), err)
+	}
+}
+
+func (w *buildLogWriter) loop() {
+	ticker := time.NewTicker(buildLogFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.mu.Lock()
+		jobIDs := make([]string, 0, len(w.pending))
+		for id := range w.pending {
+			jobIDs = append(jobIDs, id)
+		}
+		w.mu.Unlock()
+
+		for _, id := range jobIDs {
+			w.flush(id, false)
+		}
+	}
+}
+
+// RecoverInterruptedBuilds は前回のプロセス終了時に実行待ち・実行中だったビルドを失敗として記録する。
+func RecoverInterruptedBuilds() {
+	now := time.Now()
+	result := config.DB.Model(&models.Build{}).
+		Where("state IN ?", []string{models.BuildStateQueued, models.BuildStateRunning}).
+		Updates(map[string]interface{}{
+			"state":       models.BuildStateFailed,
+			"finished_at": now,
+			"error":       "interrupted by server restart",
+		})
+	if result.Error != nil {
+		log.Printf("[Build] 中断されたビルドの更新に失敗: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("[Build] 再起動により中断されたビルドを失敗として記録しました: %d件", result.RowsAffected)
+	}
+}
+
+// PurgeBuildHistory は保持期間を過ぎたビルド履歴とそのログを削除し、削除件数を返す。
+func PurgeBuildHistory(retention time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-retention)
+	oldBuilds := config.DB.Unscoped().Model(&models.Build{}).Select("id").Where("created_at < ?", cutoff)
+
+	if err := config.DB.Where("build_id IN (?)", oldBuilds).Delete(&models.BuildLogLine{}).Error; err != nil {
+		return 0, err
+	}
+	if err := config.DB.Where("build_id IN (?)", oldBuilds).Delete(&models.BuildTarget{}).Error; err != nil {
+		return 0, err
+	}
+	result := config.DB.Unscoped().Where("created_at < ?", cutoff).Delete(&models.Build{})
+	return result.RowsAffected, result.Error
+}
+
+// StartBuildRetention は BUILD_RETENTION_DAYS 日を過ぎたビルド履歴を1日1回削除する。
+// 0 以下を指定した場合は削除しない。
+func StartBuildRetention() {
+	days := config.GetEnvInt("BUILD_RETENTION_DAYS", 90)
+	if days <= 0 {
+		log.Println("[Build] BUILD_RETENTION_DAYS が0以下のため、ビルド履歴は無期限に保持します。")
+		return
+	}
+
+	go func() {
+		for {
+			n, err := PurgeBuildHistory(time.Duration(days) * 24 * time.Hour)
+			if err != nil {
+				log.Printf("[Build] 古いビルド履歴の削除に失敗: %v", err)
+			} else if n > 0 {
+				log.Printf("[Build] 保持期間(%d日)を過ぎたビルド履歴を削除しました: %d件", days, n)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 通知イベントの種類
+const (
+	NotifyEventFailure  = "failure"  // ビルド失敗
+	NotifyEventRecovery = "recovery" // 失敗の後にビルドが成功した
+	NotifyEventSuccess  = "success"  // ビルド成功（recovery に該当するものを除く）
+	NotifyEventTest     = "test"     // 管理画面からの疎通確認
+)
+
+// BuildNotification は通知チャネルに送るビルド結果
+type BuildNotification struct {
+	Event      string     `json:"event"`
+	BuildID    string     `json:"build_id"`
+	State      string     `json:"state"`
+	Source     string     `json:"trigger_source"`
+	Action     string     `json:"action"`
+	ArticleIDs []string   `json:"article_ids"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	LogTail    []string   `json:"log_tail"`
+}
+
+// Title は通知の件名を返す。
+func (n BuildNotification) Title() string {
+	switch n.Event {
+	case NotifyEventFailure:
+		return "🚨 フロントエンドビルド失敗通知"
+	case NotifyEventRecovery:
+		return "✅ フロントエンドビルド復旧通知"
+	case NotifyEventSuccess:
+		return "✅ フロントエンドビルド成功通知"
+	default:
+		return "🔔 ビルド通知のテスト"
+	}
+}
+
+// Text はチャット・メール向けの本文を返す。withLog が true の場合はログの末尾を含める。
+func (n BuildNotification) Text(withLog bool) string {
+	var b strings.Builder
+	b.WriteString(n.Title() + "\n")
+	fmt.Fprintf(&b, "ビルドID: %s\n", n.BuildID)
+	fmt.Fprintf(&b, "アクション: %s\n", n.Action)
+	fmt.Fprintf(&b, "記事ID: %s\n", strings.Join(n.ArticleIDs, ","))
+	if n.Duration != "" {
+		fmt.Fprintf(&b, "所要時間: %s\n", n.Duration)
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&b, "エラー: %s\n", n.Error)
+	}
+	if withLog && len(n.LogTail) > 0 {
+		fmt.Fprintf(&b, "ログ（末尾%d行）:\n```\n%s\n```", len(n.LogTail), strings.Join(n.LogTail, "\n"))
+	}
+	return b.String()
+}
+
+// notifySettings は通知の設定
+// BUILD_NOTIFY_EVENTS: 通知するイベント（カンマ区切り、デフォルト "failure,recovery"）
+// BUILD_NOTIFY_LOG_LINES: 通知に含めるログの行数（デフォルト30）
+// BUILD_NOTIFY_RETRIES: 失敗時の再送回数（デフォルト3）
+// BUILD_NOTIFY_BACKOFF: 再送間隔の初期値。再送ごとに2倍にする（デフォルト2秒）
+type notifySettings struct {
+	events    map[string]bool
+	logLines  int
+	retries   int
+	backoff   time.Duration
+	notifiers []Notifier
+}
+
+var (
+	notifyConfig     *notifySettings
+	notifyConfigOnce sync.Once
+	notifyConfigMu   sync.RWMutex
+)
+
+// notifiersFromEnv は環境変数で設定された通知チャネルを構成する。
+// SLACK_WEBHOOK_URL, DISCORD_WEBHOOK_URL, BUILD_WEBHOOK_URL（BUILD_WEBHOOK_SECRET で署名）,
+// BUILD_NOTIFY_EMAIL_TO（カンマ区切り）
+func notifiersFromEnv() []Notifier {
+	var notifiers []Notifier
+	if url := config.GetEnv("SLACK_WEBHOOK_URL", ""); url != "" {
+		notifiers = append(notifiers, &SlackNotifier{WebhookURL: url})
+	}
+	if url := config.GetEnv("DISCORD_WEBHOOK_URL", ""); url != "" {
+		notifiers = append(notifiers, &DiscordNotifier{WebhookURL: url})
+	}
+	if url := config.GetEnv("BUILD_WEBHOOK_URL", ""); url != "" {
+		notifiers = append(notifiers, &WebhookNotifier{URL: url, Secret: config.GetEnv("BUILD_WEBHOOK_SECRET", "")})
+	}
+	if to := splitList(config.GetEnv("BUILD_NOTIFY_EMAIL_TO", "")); len(to) > 0 {
+		notifiers = append(notifiers, &EmailNotifier{To: to})
+	}
+	return notifiers
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getNotifySettings() *notifySettings {
+	notifyConfigOnce.Do(func() {
+		notifyConfigMu.Lock()
+		defer notifyConfigMu.Unlock()
+		if notifyConfig != nil {
+			return
+		}
+
+		s := &notifySettings{
+			events:    map[string]bool{},
+			logLines:  config.GetEnvInt("BUILD_NOTIFY_LOG_LINES", 30),
+			retries:   config.GetEnvInt("BUILD_NOTIFY_RETRIES", 3),
+			backoff:   config.GetEnvDuration("BUILD_NOTIFY_BACKOFF", 2*time.Second),
+			notifiers: notifiersFromEnv(),
+		}
+		for _, ev := range splitList(config.GetEnv("BUILD_NOTIFY_EVENTS", "failure,recovery")) {
+			s.events[ev] = true
+		}
+		if len(s.notifiers) == 0 {
+			log.Println("[Notify] 通知チャネルが設定されていません。ビルド通知はログにのみ出力されます。")
+		}
+		notifyConfig = s
+	})
+
+	notifyConfigMu.RLock()
+	defer notifyConfigMu.RUnlock()
+	return notifyConfig
+}
+
+// SetNotifiers は通知チャネルを差し替える（ローカルの受信サーバーでの確認用）。
+func SetNotifiers(notifiers ...Notifier) {
+	settings := *getNotifySettings()
+	settings.notifiers = notifiers
+
+	notifyConfigMu.Lock()
+	defer notifyConfigMu.Unlock()
+	notifyConfig = &settings
+}
+
+// notifyBuildFinished は終了したビルドの結果から通知イベントを判定し、設定されたチャネルに非同期で送信する。
+// キャンセルされたビルドは通知しない。
+func notifyBuildFinished(jobID string, state BuildState) {
+	if state != BuildStateSuccess && state != BuildStateFailed {
+		return
+	}
+	settings := getNotifySettings()
+
+	go func() {
+		var build models.Build
+		if err := config.DB.Where("id = ?", jobID).First(&build).Error; err != nil {
+			log.Printf("[Notify] ビルド履歴の取得に失敗: job=%s err=%v", jobID, err)
+			return
+		}
+
+		event := NotifyEventFailure
+		if state == BuildStateSuccess {
+			event = NotifyEventSuccess
+			if previousBuildFailed(&build) {
+				event = NotifyEventRecovery
+			}
+		}
+		if !settings.events[event] {
+			return
+		}
+
+		n := buildNotificationFor(&build, event, settings.logLines)
+		log.Printf("[Build Notification] %s", n.Text(true))
+		dispatchNotification(settings, n)
+	}()
+}
+
+// previousBuildFailed は直前に終了したビルド（キャンセルを除く）が失敗だったかを返す。
+func previousBuildFailed(build *models.Build) bool {
+	if build.FinishedAt == nil {
+		return false
+	}
+	var prev models.Build
+	err := config.DB.Select("state").
+		Where("id <> ? AND state IN ? AND finished_at <= ?", build.ID,
+			[]string{models.BuildStateSuccess, models.BuildStateFailed}, build.FinishedAt).
+		Order("finished_at desc").First(&prev).Error
+	return err == nil && prev.State == models.BuildStateFailed
+}
+
+func buildNotificationFor(build *models.Build, event string, logLines int) BuildNotification {
+	n := BuildNotification{
+		Event:      event,
+		BuildID:    build.ID.String(),
+		State:      build.State,
+		Source:     build.TriggerSource,
+		Action:     build.Action,
+		ArticleIDs: append([]string{}, build.ArticleIDs...),
+		Error:      build.Error,
+		StartedAt:  build.StartedAt,
+		FinishedAt: build.FinishedAt,
+		LogTail:    []string{},
+	}
+	if build.StartedAt != nil && build.FinishedAt != nil {
+		n.Duration = build.FinishedAt.Sub(*build.StartedAt).Round(time.Millisecond).String()
+	}
+
+	if logLines > 0 {
+		var lines []models.BuildLogLine
+		if err := config.DB.Where("build_id = ?", build.ID).Order("seq desc").Limit(logLines).Find(&lines).Error; err != nil {
+			log.Printf("[Notify] ビルドログの取得に失敗: job=%s err=%v", build.ID, err)
+		}
+		for i := len(lines) - 1; i >= 0; i-- {
+			n.LogTail = append(n.LogTail, lines[i].Line)
+		}
+	}
+	return n
+}
+
+// dispatchNotification は各チャネルへ並行して送信する。失敗した場合は指数バックオフで再送する。
+func dispatchNotification(settings *notifySettings, n BuildNotification) {
+	var wg sync.WaitGroup
+	for _, notifier := range settings.notifiers {
+		wg.Add(1)
+		go func(notifier Notifier) {
+			defer wg.Done()
+			if err := notifyWithRetry(notifier, n, settings.retries, settings.backoff); err != nil {
+				log.Printf("[Notify] %s への通知に失敗: event=%s build=%s err=%v", notifier.Name(), n.Event, n.BuildID, err)
+			}
+		}(notifier)
+	}
+	wg.Wait()
+}
+
+func notifyWithRetry(notifier Notifier, n BuildNotification, retries int, backoff time.Duration) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyHTTPClient.Timeout)
+		err = notifier.Notify(ctx, n)
+		cancel()
+
+		var permanent *permanentNotifyError
+		if err == nil || errors.As(err, &permanent) || attempt >= retries {
+			return err
+		}
+
+		wait := backoff << attempt
+		log.Printf("[Notify] %s への通知に失敗したため %v 後に再送します (%d/%d): %v", notifier.Name(), wait, attempt+1, retries, err)
+		time.Sleep(wait)
+	}
+}
+
+// SendTestNotification は設定された全チャネルにテスト通知を送信し、チャネルごとの結果を返す。
+// 再送は行わない。
+func SendTestNotification() map[string]string {
+	settings := getNotifySettings()
+	n := BuildNotification{
+		Event:      NotifyEventTest,
+		BuildID:    models.NewUUIDv7().String(),
+		State:      string(BuildStateSuccess),
+		Source:     "manual",
+		Action:     "test",
+		ArticleIDs: []string{},
+		LogTail:    []string{"This is a test notification."},
+	}
+
+	results := map[string]string{}
+	for _, notifier := range settings.notifiers {
+		if err := notifyWithRetry(notifier, n, 0, 0); err != nil {
+			results[notifier.Name()] = err.Error()
+		} else {
+			results[notifier.Name()] = "ok"
+		}
+	}
+	return results
+}
@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"fmt"
+	"k-cms/config"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ターゲットの種類
+const (
+	PipelineTypeCommand = "command" // 外部コマンドを実行する（デフォルト）
+	PipelineTypeStatic  = "static"  // 組み込みの静的サイト出力（Node.js 不要）
+)
+
+// パイプラインの実行方式
+const (
+	PipelineModeSequential = "sequential" // 定義順に1つずつ実行し、失敗したら残りをスキップする
+	PipelineModeParallel   = "parallel"   // 全ターゲットを同時に実行する
+)
+
+// BuildPipeline はビルドで実行するターゲットの定義（BUILD_PIPELINE_CONFIG で指定した YAML/TOML）
+//
+//	mode: sequential
+//	targets:
+//	  - name: production
+//	    command: /bin/bash
+//	    args: ["scripts/build_frontend.sh", "{action}", "{article_ids}"]
+//	    dir: /app
+//	    env: {DEPLOY_DIR: /var/www/html/blog}
+//	    timeout: 5m
+//	    events: ["*"]
+//	    deploy: {root: /var/www/html/blog, keep: 5}
+//	  - name: static-site
+//	    type: static
+//	    static: {theme: /etc/k-cms/theme, base_url: https://www.katori.dev}
+//	    deploy: {root: /var/www/html/static}
+type BuildPipeline struct {
+	Mode    string           `yaml:"mode" toml:"mode"`
+	Targets []PipelineTarget `yaml:"targets" toml:"targets"`
+}
+
+// PipelineTarget はパイプラインのターゲット1つ分（本番サイト、プレビューサイト、検索インデックスなど）
+// Args には {build_id}, {action}, {actions}, {article_ids}, {release_dir} のプレースホルダーを使用できる。
+// Events にはこのターゲットを実行するアクション（create, update, delete, update_site_config, rebuild, preview など）を指定する。
+// 空または "*" の場合は preview 以外の全てのビルドで実行する。
+type PipelineTarget struct {
+	Name    string              `yaml:"name" toml:"name" json:"name"`
+	Type    string              `yaml:"type" toml:"type" json:"type"`
+	Command string              `yaml:"command" toml:"command" json:"command"`
+	Args    []string            `yaml:"args" toml:"args" json:"args"`
+	Dir     string              `yaml:"dir" toml:"dir" json:"dir"`
+	Env     map[string]string   `yaml:"env" toml:"env" json:"-"`
+	Timeout string              `yaml:"timeout" toml:"timeout" json:"timeout"`
+	Events  []string            `yaml:"events" toml:"events" json:"events"`
+	Deploy  *PipelineDeploy     `yaml:"deploy" toml:"deploy" json:"deploy,omitempty"`
+	Static  *StaticTargetConfig `yaml:"static" toml:"static" json:"static,omitempty"`
+
+	timeout time.Duration
+}
+
+var pipelineTargetName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// BuildActionPreview は下書きのプレビュー用ビルドのアクション。
+// 下書きを公開用のターゲットで扱わないよう、events に "preview" を明示したターゲットでのみ実行する（"*" には含まれない）。
+const BuildActionPreview = "preview"
+
+// BuildActionComment はコメントの承認などで、記事の公開中のコメントが変わった場合のビルドのアクション。
+// 組み込みの静的サイト出力はコメントを出力しないため、static のターゲットはこのアクションでは実行しない。
+const BuildActionComment = "comment"
+
+// matches はターゲットがいずれかのアクションで実行対象になるかを返す。
+func (t *PipelineTarget) matches(actions []string) bool {
+	all := len(t.Events) == 0 || containsString(t.Events, "*")
+	if len(actions) == 0 {
+		return all
+	}
+	for _, action := range actions {
+		if action == BuildActionComment && t.Type == PipelineTypeStatic {
+			continue
+		}
+		if action == BuildActionPreview {
+			if containsString(t.Events, action) {
+				return true
+			}
+			continue
+		}
+		if all || containsString(t.Events, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetsFor はジョブのアクションで実行するターゲットを定義順に返す。
+func (p *BuildPipeline) targetsFor(actions []string) []PipelineTarget {
+	var targets []PipelineTarget
+	for _, t := range p.Targets {
+		if t.matches(actions) {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+func (p *BuildPipeline) validate() error {
+	switch p.Mode {
+	case "":
+		p.Mode = PipelineModeSequential
+	case PipelineModeSequential, PipelineModeParallel:
+	default:
+		return fmt.Errorf("unknown mode %q", p.Mode)
+	}
+
+	seen := map[string]bool{}
+	deployRoots := map[string]bool{}
+	for i := range p.Targets {
+		t := &p.Targets[i]
+		if !pipelineTargetName.MatchString(t.Name) {
+			return fmt.Errorf("targets[%d]: invalid name %q", i, t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("targets[%d]: duplicate name %q", i, t.Name)
+		}
+		seen[t.Name] = true
+		switch t.Type {
+		case "", PipelineTypeCommand:
+			t.Type = PipelineTypeCommand
+			if t.Command == "" {
+				return fmt.Errorf("target %s: command is required", t.Name)
+			}
+		case PipelineTypeStatic:
+			if t.Static == nil {
+				t.Static = &StaticTargetConfig{}
+			}
+			if err := t.Static.validate(t.Deploy != nil); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+			if containsString(t.Events, BuildActionComment) {
+				return fmt.Errorf("target %s: static targets do not render comments and cannot run on %q", t.Name, BuildActionComment)
+			}
+		default:
+			return fmt.Errorf("target %s: unknown type %q", t.Name, t.Type)
+		}
+
+		t.timeout = buildTimeout
+		if t.Timeout != "" {
+			d, err := time.ParseDuration(t.Timeout)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("target %s: invalid timeout %q", t.Name, t.Timeout)
+			}
+			t.timeout = d
+		}
+		if t.Deploy != nil {
+			if err := t.Deploy.validate(); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+			if deployRoots[t.Deploy.Root] {
+				return fmt.Errorf("target %s: deploy.root %q is used by another target", t.Name, t.Deploy.Root)
+			}
+			deployRoots[t.Deploy.Root] = true
+		}
+	}
+	return nil
+}
+
+// loadBuildPipeline は BUILD_PIPELINE_CONFIG のファイルを読み込む。
+// 未設定の場合は BUILD_SCRIPT_PATH のスクリプトを実行するターゲット1つのパイプラインとする（従来の動作）。
+// その場合 BUILD_DEPLOY_ROOT を設定するとアトミックデプロイを行う（保持数は BUILD_DEPLOY_KEEP）。
+func loadBuildPipeline() (*BuildPipeline, error) {
+	path := config.GetEnv("BUILD_PIPELINE_CONFIG", "")
+	if path == "" {
+		p := &BuildPipeline{Mode: PipelineModeSequential}
+		if script := config.GetEnv("BUILD_SCRIPT_PATH", ""); script != "" {
+			p.Targets = []PipelineTarget{{
+				Name:    "default",
+				Command: "/bin/bash",
+				Args:    []string{script, "{action}", "{article_ids}"},
+			}}
+			if root := config.GetEnv("BUILD_DEPLOY_ROOT", ""); root != "" {
+				p.Targets[0].Deploy = &PipelineDeploy{Root: root, Keep: config.GetEnvInt("BUILD_DEPLOY_KEEP", 5)}
+			}
+		}
+		return p, p.validate()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &BuildPipeline{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, p)
+	case ".toml":
+		err = toml.Unmarshal(data, p)
+	default:
+		return nil, fmt.Errorf("unsupported pipeline config format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+var (
+	buildPipeline     *BuildPipeline
+	buildPipelineErr  error
+	buildPipelineOnce sync.Once
+)
+
+// LoadBuildPipeline はビルドパイプラインの設定を読み込む（読み込みは初回のみ）。
+// 設定に誤りがある場合は起動時に検出できるよう main から呼び出す。
+func LoadBuildPipeline() (*BuildPipeline, error) {
+	buildPipelineOnce.Do(func() {
+		buildPipeline, buildPipelineErr = loadBuildPipeline()
+		if buildPipelineErr == nil {
+			names := make([]string, 0, len(buildPipeline.Targets))
+			for _, t := range buildPipeline.Targets {
+				names = append(names, t.Name)
+			}
+			log.Printf("[Build] ビルドパイプラインを読み込みました: mode=%s targets=%s", buildPipeline.Mode, strings.Join(names, ","))
+		}
+	})
+	return buildPipeline, buildPipelineErr
+}
+
+// getBuildPipeline は読み込み済みのパイプラインを返す。読み込みに失敗している場合はターゲット無しとして扱う。
+func getBuildPipeline() *BuildPipeline {
+	p, err := LoadBuildPipeline()
+	if err != nil {
+		return &BuildPipeline{Mode: PipelineModeSequential}
+	}
+	return p
+}
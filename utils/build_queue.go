@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// BuildRequest はビルドの要求1件分
+type BuildRequest struct {
+	Source     string     // article, site_config, manual, retry
+	Action     string     // create, update, delete, rebuild など
+	ArticleID  string     // 対象記事ID（無ければ空）
+	UserID     *uuid.UUID // 要求したユーザー（無ければ nil）
+	Actions    []string   // 再実行時など、まとめられた複数のアクションを引き継ぐ場合に指定
+	ArticleIDs []string   // 再実行時など、複数の対象記事IDを引き継ぐ場合に指定
+	RetryOf    *uuid.UUID // 再実行元のビルド
+
+	PreviewToken string // プレビュー用ビルドでターゲットに渡すプレビュートークン（履歴には保存しない）
+}
+
+// BuildJob はキューに積まれたビルド要求。
+// 実行開始前に届いた要求は1つのジョブにまとめられ、対象記事IDが追加されていく。
+type BuildJob struct {
+	ID         string     `json:"id"`
+	Source     string     `json:"source"`  // 単一の要求元ならその値、複数混在なら "batch"
+	Action     string     `json:"action"`  // 単一のアクションならその値、複数混在なら "batch"
+	Actions    []string   `json:"actions"` // まとめられたアクションの一覧（重複なし）
+	ArticleIDs []string   `json:"article_ids"`
+	UserID     *uuid.UUID `json:"user_id"`  // 最初に要求したユーザー
+	RetryOf    *uuid.UUID `json:"retry_of"` // 再実行元のビルド
+	QueuedAt   time.Time  `json:"queued_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// プレビュー用ビルドのトークン（BUILD_PREVIEW_TOKENS でターゲットに渡す。レスポンスや履歴には含めない）
+	PreviewTokens []string `json:"-"`
+}
+
+// merge は要求をジョブにまとめる。
+func (j *BuildJob) merge(req BuildRequest) {
+	if j.Source != req.Source {
+		j.Source = "batch"
+	}
+	for _, action := range append([]string{req.Action}, req.Actions...) {
+		if action != "" && !containsString(j.Actions, action) {
+			j.Actions = append(j.Actions, action)
+		}
+	}
+	if len(j.Actions) > 1 {
+		j.Action = "batch"
+	}
+	for _, id := range append([]string{req.ArticleID}, req.ArticleIDs...) {
+		if id != "" && !containsString(j.ArticleIDs, id) {
+			j.ArticleIDs = append(j.ArticleIDs, id)
+		}
+	}
+	if req.PreviewToken != "" && !containsString(j.PreviewTokens, req.PreviewToken) {
+		j.PreviewTokens = append(j.PreviewTokens, req.PreviewToken)
+	}
+	if j.UserID == nil {
+		j.UserID = req.UserID
+	}
+	if j.RetryOf == nil {
+		j.RetryOf = req.RetryOf
+	}
+	j.UpdatedAt = time.Now()
+}
+
+func (j *BuildJob) clone() BuildJob {
+	c := *j
+	c.Actions = append([]string(nil), j.Actions...)
+	c.ArticleIDs = append([]string(nil), j.ArticleIDs...)
+	c.PreviewTokens = append([]string(nil), j.PreviewTokens...)
+	return c
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// buildQueue はビルド要求をデバウンス・集約し、決まった数のワーカーで順に実行する。
+// 同じターゲットを使うジョブは同時に実行しない（出力先やリリースの切り替えが競合するため）。
+type buildQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queued   *BuildJob // 実行待ちのジョブ（新しい要求はここにまとめられる）
+	debounce time.Duration
+	maxDelay time.Duration
+	run      func(job *BuildJob)
+	targets  func(job *BuildJob) []string // ジョブが実行するターゲット名
+	running  map[string]bool              // 実行中のジョブが使っているターゲット
+
+	// 実行待ちのジョブの履歴の書き込み中の件数（書き込みが終わるまでジョブを取り出さない）
+	recording int
+	// recordMu は履歴の書き込みを直列化する（DB への書き込みは mu の外で行う）
+	recordMu   sync.Mutex
+	recordedID string // 履歴を作成済みの実行待ちのジョブ
+}
+
+var (
+	queue     *buildQueue
+	queueOnce sync.Once
+)
+
+// getBuildQueue はビルドキューを初期化してワーカーを起動する。
+// BUILD_WORKERS: 同時に実行するビルド数（デフォルト1、同じターゲットを使うジョブは直列に実行する）
+// BUILD_DEBOUNCE: 最後の要求からビルド開始までの待ち時間（デフォルト3秒）
+// BUILD_DEBOUNCE_MAX: 要求が続いても最初の要求からこの時間が経てば開始する（デフォルト1分）
+func getBuildQueue() *buildQueue {
+	queueOnce.Do(func() {
+		queue = &buildQueue{
+			debounce: config.GetEnvDuration("BUILD_DEBOUNCE", 3*time.Second),
+			maxDelay: config.GetEnvDuration("BUILD_DEBOUNCE_MAX", time.Minute),
+			run:      executeBuild,
+			targets:  jobTargetNames,
+			running:  map[string]bool{},
+		}
+		queue.cond = sync.NewCond(&queue.mu)
+
+		workers := config.GetEnvInt("BUILD_WORKERS", 1)
+		if workers < 1 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			go queue.worker()
+		}
+		log.Printf("[Build] ビルドキューを開始: workers=%d debounce=%v max_delay=%v", workers, queue.debounce, queue.maxDelay)
+	})
+	return queue
+}
+
+// jobTargetNames はジョブのアクションに該当するパイプラインのターゲット名を返す。
+func jobTargetNames(job *BuildJob) []string {
+	var names []string
+	for _, t := range getBuildPipeline().targetsFor(job.Actions) {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// enqueue は要求を実行待ちのジョブにまとめる。実行待ちのジョブが無ければ新しく作る。
+func (q *buildQueue) enqueue(req BuildRequest) BuildJob {
+	q.mu.Lock()
+	if q.queued == nil {
+		q.queued = &BuildJob{
+			ID:       models.NewUUIDv7().String(),
+			Source:   req.Source,
+			Action:   req.Action,
+			QueuedAt: time.Now(),
+		}
+	}
+	q.queued.merge(req)
+	job := q.queued.clone()
+	// 履歴を書き込み終えるまでジョブを取り出させない（開始処理が履歴の作成より先に行われないようにする）
+	q.recording++
+	q.mu.Unlock()
+
+	q.recordQueued()
+
+	q.mu.Lock()
+	q.recording--
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	return job
+}
+
+// recordQueued は実行待ちのジョブの最新の状態を履歴に書き込む。
+// 書き込みは recordMu で直列化し、後から書き込む側が常に最新の状態を書くため、古い内容で上書きされることはない。
+func (q *buildQueue) recordQueued() {
+	q.recordMu.Lock()
+	defer q.recordMu.Unlock()
+
+	q.mu.Lock()
+	if q.queued == nil {
+		// recording が残っている間は取り出し・キャンセルされないため通常は起きない
+		q.mu.Unlock()
+		return
+	}
+	job := q.queued.clone()
+	isNew := q.recordedID != job.ID
+	q.recordedID = job.ID
+	q.mu.Unlock()
+
+	if isNew {
+		createBuildRecord(&job)
+	} else {
+		updateQueuedBuildRecord(&job)
+	}
+}
+
+// next は実行可能になったジョブを取り出す（デバウンス期間が過ぎ、同じターゲットを使うジョブが終わるまでブロックする）。
+// 取り出したジョブのターゲットは実行中として登録し、done で解放する。
+func (q *buildQueue) next() (job *BuildJob, targets []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.queued != nil && q.recording == 0 {
+			wait := q.debounce - time.Since(q.queued.UpdatedAt)
+			if rest := q.maxDelay - time.Since(q.queued.QueuedAt); rest < wait {
+				wait = rest
+			}
+			if wait > 0 {
+				time.AfterFunc(wait, q.cond.Broadcast)
+			} else if targets := q.targets(q.queued); !q.busyLocked(targets) {
+				job := q.queued
+				q.queued = nil
+				for _, name := range targets {
+					q.running[name] = true
+				}
+				return job, targets
+			}
+			// ターゲットが実行中の場合は done の Broadcast を待つ（その間の要求は同じジョブにまとめられる）
+		}
+		q.cond.Wait()
+	}
+}
+
+// busyLocked はターゲットのいずれかを実行中のジョブが使っているかを返す（q.mu を保持した状態で呼ぶ）。
+func (q *buildQueue) busyLocked(targets []string) bool {
+	for _, name := range targets {
+		if q.running[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// done はジョブの実行が終わったターゲットを解放する。
+func (q *buildQueue) done(targets []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, name := range targets {
+		delete(q.running, name)
+	}
+	q.cond.Broadcast()
+}
+
+func (q *buildQueue) worker() {
+	for {
+		job, targets := q.next()
+		q.run(job)
+		q.done(targets)
+	}
+}
+
+// cancelQueued は実行待ちのジョブが jobID であれば取り除く。取り除いた場合は true を返す。
+func (q *buildQueue) cancelQueued(jobID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// 履歴の作成前に取り除くと、後から queued の履歴が作られてしまうため書き込みを待つ
+	for q.recording > 0 {
+		q.cond.Wait()
+	}
+	if q.queued == nil || q.queued.ID != jobID {
+		return false
+	}
+	q.queued = nil
+	q.cond.Broadcast()
+	return true
+}
+
+// pending は実行待ちのジョブを返す。
+func (q *buildQueue) pending() []BuildJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queued == nil {
+		return []BuildJob{}
+	}
+	return []BuildJob{q.queued.clone()}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -9,72 +10,152 @@ import (
 type BuildState string
 
 const (
-	BuildStateIdle    BuildState = "idle"
-	BuildStateRunning BuildState = "running"
-	BuildStateSuccess BuildState = "success"
-	BuildStateFailed  BuildState = "failed"
+	BuildStateIdle     BuildState = "idle"
+	BuildStateQueued   BuildState = "queued"
+	BuildStateRunning  BuildState = "running"
+	BuildStateSuccess  BuildState = "success"
+	BuildStateFailed   BuildState = "failed"
+	BuildStateCanceled BuildState = "canceled"
+	BuildStateSkipped  BuildState = "skipped" // パイプラインのターゲットのみ
 )
 
 // BuildStatus 構造体
 type BuildStatus struct {
-	State     BuildState `json:"state"`
-	Logs      []string   `json:"logs"`
-	StartTime time.Time  `json:"start_time"`
-	EndTime   time.Time  `json:"end_time"`
-	Action    string     `json:"action"` // create, update, delete
-	ArticleID string     `json:"article_id"`
+	JobID      string         `json:"job_id"`
+	State      BuildState     `json:"state"`
+	Logs       []string       `json:"logs"`
+	StartTime  time.Time      `json:"start_time"`
+	EndTime    time.Time      `json:"end_time"`
+	Action     string         `json:"action"`      // create, update, delete, batch
+	ArticleID  string         `json:"article_id"`  // 対象記事IDをカンマ区切りで連結したもの
+	ArticleIDs []string       `json:"article_ids"` // まとめられた対象記事ID
+	Targets    []TargetStatus `json:"targets"`     // パイプラインのターゲットごとの状態
+	Queued     []BuildJob     `json:"queued"`      // 実行待ちのジョブ
+}
+
+// TargetStatus はパイプラインのターゲット1つ分の状態
+type TargetStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
 }
 
 // BuildStore はビルド状態を管理します
+// 複数ワーカーで同時に実行される場合に備えてジョブごとに保持し、
+// GetBuildStatus は最後に開始されたビルドの状態を返します
 var (
-	currentStatus BuildStatus
-	statusMutex   sync.RWMutex
+	statuses    map[string]*BuildStatus
+	latestJobID string
+	statusMutex sync.RWMutex
 )
 
 func init() {
-	currentStatus = BuildStatus{
-		State: BuildStateIdle,
-		Logs:  []string{},
-	}
+	statuses = map[string]*BuildStatus{}
 }
 
 // SetBuildStart はビルド開始を設定します
-func SetBuildStart(action, articleID string) {
+func SetBuildStart(job *BuildJob) {
+	startTime := time.Now()
+	markBuildStarted(job.ID, startTime)
+
 	statusMutex.Lock()
 	defer statusMutex.Unlock()
 
-	currentStatus = BuildStatus{
-		State:     BuildStateRunning,
-		Logs:      []string{},
-		StartTime: time.Now(),
-		Action:    action,
-		ArticleID: articleID,
+	statuses[job.ID] = &BuildStatus{
+		JobID:      job.ID,
+		State:      BuildStateRunning,
+		Logs:       []string{},
+		StartTime:  startTime,
+		Action:     job.Action,
+		ArticleID:  strings.Join(job.ArticleIDs, ","),
+		ArticleIDs: append([]string(nil), job.ArticleIDs...),
+		Targets:    []TargetStatus{},
 	}
+	latestJobID = job.ID
 }
 
 const maxBuildLogLines = 1000
 
-// AppendBuildLog はログを追加します
-func AppendBuildLog(logLine string) {
+// AppendBuildLog はログを追加します（DBのビルド履歴にも順次書き出されます）
+func AppendBuildLog(jobID, logLine string) {
+	appendTargetLog(jobID, "", logLine)
+}
+
+// appendTargetLog はパイプラインのターゲットが出力したログを追加します
+func appendTargetLog(jobID, target, logLine string) {
+	buildLogs.append(jobID, target, logLine)
+	if target != "" {
+		logLine = "[" + target + "] " + logLine
+	}
+
 	statusMutex.Lock()
 	defer statusMutex.Unlock()
 
-	currentStatus.Logs = append(currentStatus.Logs, logLine)
-	if len(currentStatus.Logs) > maxBuildLogLines {
-		currentStatus.Logs = currentStatus.Logs[len(currentStatus.Logs)-maxBuildLogLines:]
+	status, ok := statuses[jobID]
+	if !ok {
+		return
+	}
+	status.Logs = append(status.Logs, logLine)
+	if len(status.Logs) > maxBuildLogLines {
+		status.Logs = status.Logs[len(status.Logs)-maxBuildLogLines:]
 	}
 }
 
-// SetBuildComplete はビルド完了（成功/失敗）を設定します
-func SetBuildComplete(success bool) {
+// setTargetState はターゲットの状態を更新します
+func setTargetState(jobID, target, state string, updates map[string]interface{}) {
+	updateBuildTargetRecord(jobID, target, state, updates)
+
 	statusMutex.Lock()
 	defer statusMutex.Unlock()
 
-	currentStatus.EndTime = time.Now()
+	status, ok := statuses[jobID]
+	if !ok {
+		return
+	}
+	for i := range status.Targets {
+		if status.Targets[i].Name == target {
+			status.Targets[i].State = state
+			return
+		}
+	}
+	status.Targets = append(status.Targets, TargetStatus{Name: target, State: state})
+}
+
+// SetBuildComplete はビルド完了（成功/失敗）を設定します
+// exitCode はスクリプトの終了コード（起動できなかった場合などは -1）
+func SetBuildComplete(jobID string, success bool, exitCode int, errorMsg string) {
+	state := BuildStateFailed
 	if success {
-		currentStatus.State = BuildStateSuccess
-	} else {
-		currentStatus.State = BuildStateFailed
+		state = BuildStateSuccess
+	}
+	setBuildFinished(jobID, state, exitCode, errorMsg)
+}
+
+// SetBuildCanceled はビルドがキャンセルされたことを設定します
+func SetBuildCanceled(jobID string, exitCode int) {
+	setBuildFinished(jobID, BuildStateCanceled, exitCode, "canceled")
+}
+
+func setBuildFinished(jobID string, state BuildState, exitCode int, errorMsg string) {
+	endTime := time.Now()
+	// 終了を記録する前に登録を解除し、終了処理と並行して CancelBuild がログや canceled_by を書き込まないようにする
+	unregisterRunningBuild(jobID)
+	markBuildFinished(jobID, state, endTime, exitCode, errorMsg)
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status, ok := statuses[jobID]
+	if !ok {
+		return
+	}
+	status.EndTime = endTime
+	status.State = state
+
+	// 実行中のものと最新のもの以外は破棄する
+	for id, s := range statuses {
+		if id != latestJobID && s.State != BuildStateRunning {
+			delete(statuses, id)
+		}
 	}
 }
 
@@ -83,15 +164,13 @@ func GetBuildStatus() BuildStatus {
 	statusMutex.RLock()
 	defer statusMutex.RUnlock()
 
-	logsCopy := make([]string, len(currentStatus.Logs))
-	copy(logsCopy, currentStatus.Logs)
-
-	return BuildStatus{
-		State:     currentStatus.State,
-		Logs:      logsCopy,
-		StartTime: currentStatus.StartTime,
-		EndTime:   currentStatus.EndTime,
-		Action:    currentStatus.Action,
-		ArticleID: currentStatus.ArticleID,
+	result := BuildStatus{State: BuildStateIdle, Logs: []string{}, ArticleIDs: []string{}, Targets: []TargetStatus{}}
+	if current, ok := statuses[latestJobID]; ok {
+		result = *current
+		result.Logs = append([]string{}, current.Logs...)
+		result.ArticleIDs = append([]string{}, current.ArticleIDs...)
+		result.Targets = append([]TargetStatus{}, current.Targets...)
 	}
+	result.Queued = getBuildQueue().pending()
+	return result
 }
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"errors"
+	"k-cms/config"
+	"sync"
+	"time"
+)
+
+// CounterEvent の種類
+const (
+	CounterEventLikes = "likes" // いいね・リアクションの数が変わった
+	CounterEventViews = "views" // PV数が変わった
+)
+
+// CounterEvent は記事のいいね数・PV数の更新を表す。Type に対応する項目だけを設定する。
+type CounterEvent struct {
+	ArticleID string           `json:"article_id"`
+	Type      string           `json:"type"`
+	LikeCount *int             `json:"like_count,omitempty"`
+	ViewCount *int64           `json:"view_count,omitempty"`
+	Reactions map[string]int64 `json:"reactions,omitempty"` // 種類ごとのリアクション数（購読者がいる場合のみ）
+	Time      time.Time        `json:"time"`
+}
+
+// 購読者ごとのバッファ。溢れた購読者は切断し、再接続時の最新値の送信に任せる
+const counterSubscriberBuffer = 32
+
+var (
+	ErrTooManyCounterStreams    = errors.New("too many counter streams")
+	ErrTooManyCounterArticles   = errors.New("too many articles in one counter stream")
+	ErrTooManyStreamsFromClient = errors.New("too many counter streams from this client")
+	ErrCounterStreamsClosed     = errors.New("counter streams are closed")
+)
+
+// CounterSubscription は記事（1件以上）のカウンター更新の購読
+type CounterSubscription struct {
+	C          chan CounterEvent
+	articleIDs []string
+	client     string
+	closed     bool
+}
+
+type counterEventHub struct {
+	mu       sync.Mutex
+	subs     map[string]map[*CounterSubscription]struct{}
+	total    int
+	byClient map[string]int
+	closed   bool // サーバーの終了時に closeAll した後は購読を受け付けない
+}
+
+var counterEvents = &counterEventHub{
+	subs:     map[string]map[*CounterSubscription]struct{}{},
+	byClient: map[string]int{},
+}
+
+// counterStreamLimits は同時接続数の上限（COUNTER_STREAM_MAX_CONNECTIONS）と
+// 同じクライアントからの上限（COUNTER_STREAM_MAX_PER_CLIENT）を返す。
+func counterStreamLimits() (total, perClient int) {
+	return config.GetEnvInt("COUNTER_STREAM_MAX_CONNECTIONS", 1000), config.GetEnvInt("COUNTER_STREAM_MAX_PER_CLIENT", 10)
+}
+
+// CounterStreamMaxArticles は1つの接続で購読できる記事数の上限（COUNTER_STREAM_MAX_ARTICLES）を返す。
+func CounterStreamMaxArticles() int {
+	return config.GetEnvInt("COUNTER_STREAM_MAX_ARTICLES", 50)
+}
+
+// SubscribeCounterEvents は記事のカウンター更新を購読する。client は接続数を数えるためのクライアントの識別子（IP アドレスなど）。
+func SubscribeCounterEvents(articleIDs []string, client string) (*CounterSubscription, error) {
+	if len(articleIDs) > CounterStreamMaxArticles() {
+		return nil, ErrTooManyCounterArticles
+	}
+	maxTotal, maxPerClient := counterStreamLimits()
+
+	h := counterEvents
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrCounterStreamsClosed
+	}
+	if h.total >= maxTotal {
+		return nil, ErrTooManyCounterStreams
+	}
+	if h.byClient[client] >= maxPerClient {
+		return nil, ErrTooManyStreamsFromClient
+	}
+
+	sub := &CounterSubscription{C: make(chan CounterEvent, counterSubscriberBuffer), articleIDs: articleIDs, client: client}
+	for _, id := range articleIDs {
+		if h.subs[id] == nil {
+			h.subs[id] = map[*CounterSubscription]struct{}{}
+		}
+		h.subs[id][sub] = struct{}{}
+	}
+	h.total++
+	h.byClient[client]++
+	return sub, nil
+}
+
+// removeLocked は購読を解除してチャネルを閉じる（h.mu を保持した状態で呼ぶ）。
+func (h *counterEventHub) removeLocked(sub *CounterSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.C)
+	for _, id := range sub.articleIDs {
+		delete(h.subs[id], sub)
+		if len(h.subs[id]) == 0 {
+			delete(h.subs, id)
+		}
+	}
+	h.total--
+	if h.byClient[sub.client]--; h.byClient[sub.client] <= 0 {
+		delete(h.byClient, sub.client)
+	}
+}
+
+// closeAll は全ての購読を解除してチャネルを閉じ、以降の購読も受け付けない。
+func (h *counterEventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.removeLocked(sub)
+		}
+	}
+}
+
+// CloseEventStreams はカウンターとビルドのイベントの購読を全て終了させる。
+// Server-Sent Events の接続は http.Server.Shutdown では終わらないため、終了時に呼んで接続を閉じさせる。
+func CloseEventStreams() {
+	counterEvents.closeAll()
+	buildEvents.closeAll()
+}
+
+// Close は購読を解除する。
+func (s *CounterSubscription) Close() {
+	counterEvents.mu.Lock()
+	defer counterEvents.mu.Unlock()
+	counterEvents.removeLocked(s)
+}
+
+// HasCounterSubscribers は記事の更新を購読している接続があるかを返す（配信用の追加の集計を省くために使う）。
+func HasCounterSubscribers(articleID string) bool {
+	counterEvents.mu.Lock()
+	defer counterEvents.mu.Unlock()
+	return len(counterEvents.subs[articleID]) > 0
+}
+
+// publish はイベントを購読者に配信する。リクエストの処理をブロックしないよう送信は待たず、
+// 受信が追いつかない購読者は切断する。
+func (h *counterEventHub) publish(ev CounterEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[ev.ArticleID] {
+		select {
+		case sub.C <- ev:
+		default:
+			h.removeLocked(sub)
+		}
+	}
+}
+
+// PublishLikeCount は記事のいいね数（とリアクション数）の更新を配信する。
+func PublishLikeCount(articleID string, likeCount int, reactions map[string]int64) {
+	counterEvents.publish(CounterEvent{
+		ArticleID: articleID,
+		Type:      CounterEventLikes,
+		LikeCount: &likeCount,
+		Reactions: reactions,
+		Time:      time.Now(),
+	})
+}
+
+// PublishViewCount は記事のPV数の更新を配信する。
+func PublishViewCount(articleID string, viewCount int64) {
+	counterEvents.publish(CounterEvent{
+		ArticleID: articleID,
+		Type:      CounterEventViews,
+		ViewCount: &viewCount,
+		Time:      time.Now(),
+	})
+}
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// PipelineDeploy はターゲットのアトミックデプロイの設定。
+// ビルドごとに Root/releases/<時刻>-<ビルドID> を作成してコマンドに BUILD_RELEASE_DIR で渡し、
+// ターゲットが成功したら Root/current シンボリックリンクを切り替えて公開する（Web サーバーは Root/current を配信する）。
+type PipelineDeploy struct {
+	Root string `yaml:"root" toml:"root" json:"root"`
+	Keep int    `yaml:"keep" toml:"keep" json:"keep"` // 保持するリリース数（公開中のものを含む、デフォルト5）
+}
+
+const (
+	deployReleasesDir = "releases"
+	deployCurrentLink = "current"
+)
+
+// ErrDeployNotRollbackable は削除済みなどでロールバックできないリリースを表す
+var ErrDeployNotRollbackable = errors.New("deploy is not available for rollback")
+
+// シンボリックリンクの切り替えと古いリリースの削除を排他する
+var deployMu sync.Mutex
+
+func (d *PipelineDeploy) validate() error {
+	if !filepath.IsAbs(d.Root) {
+		return fmt.Errorf("deploy.root must be an absolute path: %q", d.Root)
+	}
+	d.Root = filepath.Clean(d.Root)
+	if d.Keep == 0 {
+		d.Keep = 5
+	}
+	if d.Keep < 1 {
+		return fmt.Errorf("deploy.keep must be positive: %d", d.Keep)
+	}
+	return nil
+}
+
+// prepareRelease はビルド用のリリースディレクトリを作成してパスを返す。
+func prepareRelease(jobID string, d *PipelineDeploy) (string, error) {
+	name := time.Now().UTC().Format("20060102T150405Z") + "-" + jobID[len(jobID)-12:]
+	dir := filepath.Join(d.Root, deployReleasesDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// discardRelease は公開しなかったリリースディレクトリを削除する。
+func discardRelease(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("[Deploy] リリースディレクトリの削除に失敗: dir=%s err=%v", dir, err)
+	}
+}
+
+// switchCurrentLink は Root/current をリリースディレクトリに向ける。
+// 一時的なシンボリックリンクを作成して rename で置き換えるため、配信中に current が存在しない瞬間は無い。
+func switchCurrentLink(root, releaseDir string) error {
+	rel, err := filepath.Rel(root, releaseDir)
+	if err != nil {
+		return err
+	}
+
+	current := filepath.Join(root, deployCurrentLink)
+	if fi, err := os.Lstat(current); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a symlink", current)
+	}
+
+	tmp := filepath.Join(root, fmt.Sprintf(".%s.%d", deployCurrentLink, time.Now().UnixNano()))
+	if err := os.Symlink(rel, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, current); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// activateRelease はビルドしたリリースを公開して記録し、保持数を超えた古いリリースを削除する。
+func activateRelease(jobID, target string, d *PipelineDeploy, releaseDir string) (*models.Deploy, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+
+	if err := switchCurrentLink(d.Root, releaseDir); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	deploy := models.Deploy{
+		BuildID:     uuid.FromStringOrNil(jobID),
+		Target:      target,
+		Root:        d.Root,
+		ReleaseDir:  releaseDir,
+		Status:      models.DeployStatusActive,
+		ActivatedAt: &now,
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Deploy{}).
+			Where("root = ? AND status = ?", d.Root, models.DeployStatusActive).
+			Update("status", models.DeployStatusInactive).Error; err != nil {
+			return err
+		}
+		return tx.Create(&deploy).Error
+	})
+	if err != nil {
+		// 公開自体は完了しているので、記録の失敗はログに残すのみとする
+		log.Printf("[Deploy] デプロイ履歴の記録に失敗: target=%s dir=%s err=%v", target, releaseDir, err)
+	}
+
+	pruneReleases(d)
+	return &deploy, nil
+}
+
+// pruneReleases は公開中のものを含めて d.Keep 件を超えた古いリリースを削除する。
+func pruneReleases(d *PipelineDeploy) {
+	var old []models.Deploy
+	if err := config.DB.Where("root = ? AND status = ?", d.Root, models.DeployStatusInactive).
+		Order("created_at desc").Offset(d.Keep - 1).Find(&old).Error; err != nil {
+		log.Printf("[Deploy] 古いリリースの取得に失敗: root=%s err=%v", d.Root, err)
+		return
+	}
+
+	releases := filepath.Join(d.Root, deployReleasesDir) + string(filepath.Separator)
+	for _, dep := range old {
+		// 念のため releases ディレクトリ以下のみを削除対象とする
+		if !strings.HasPrefix(dep.ReleaseDir, releases) {
+			log.Printf("[Deploy] releases 以外のディレクトリは削除しません: %s", dep.ReleaseDir)
+			continue
+		}
+		if err := os.RemoveAll(dep.ReleaseDir); err != nil {
+			log.Printf("[Deploy] 古いリリースの削除に失敗: dir=%s err=%v", dep.ReleaseDir, err)
+			continue
+		}
+		config.DB.Model(&dep).Update("status", models.DeployStatusPruned)
+		log.Printf("[Deploy] 古いリリースを削除しました: %s", dep.ReleaseDir)
+	}
+}
+
+// RollbackDeploy は保持されているリリースを再ビルドせずに公開し直す。
+// userID にはロールバックを要求したユーザーを記録する。
+func RollbackDeploy(deployID string, userID *uuid.UUID) (*models.Deploy, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+
+	var deploy models.Deploy
+	if err := config.DB.Where("id = ?", deployID).First(&deploy).Error; err != nil {
+		return nil, err
+	}
+	if deploy.Status == models.DeployStatusPruned {
+		return nil, ErrDeployNotRollbackable
+	}
+	if fi, err := os.Stat(deploy.ReleaseDir); err != nil || !fi.IsDir() {
+		return nil, ErrDeployNotRollbackable
+	}
+
+	if err := switchCurrentLink(deploy.Root, deploy.ReleaseDir); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Deploy{}).
+			Where("root = ? AND status = ? AND id <> ?", deploy.Root, models.DeployStatusActive, deploy.ID).
+			Update("status", models.DeployStatusInactive).Error; err != nil {
+			return err
+		}
+		return tx.Model(&deploy).Updates(map[string]interface{}{
+			"status":       models.DeployStatusActive,
+			"activated_at": now,
+			"activated_by": userID,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("[Deploy] デプロイ履歴の更新に失敗: deploy=%s err=%v", deploy.ID, err)
+	}
+	deploy.Status = models.DeployStatusActive
+	deploy.ActivatedAt = &now
+	deploy.ActivatedBy = userID
+
+	log.Printf("[Deploy] ロールバックしました: target=%s dir=%s", deploy.Target, deploy.ReleaseDir)
+	return &deploy, nil
+}
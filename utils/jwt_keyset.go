@@ -0,0 +1,322 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// 鍵セットをDBから再読み込みする間隔（別プロセスからのローテーションを反映するため）
+	jwtKeysetRefreshInterval = time.Minute
+	// 未知の kid を受け取った際に再読み込みする最短間隔（不正な kid での DB 負荷を防ぐ）
+	jwtKeysetMissRefreshInterval = 10 * time.Second
+)
+
+// LegacyJWTKid はローテーション導入前の JWT_SECRET を鍵セットに登録する際の kid。
+// 鍵の値はDBに保存せず、常に JWT_SECRET から読み込む。kid の無いトークンはこの鍵で検証する。
+const LegacyJWTKid = "legacy"
+
+var (
+	ErrJWTKeyNotFound       = errors.New("jwt key not found")
+	ErrUnsupportedAlgorithm = errors.New("unsupported jwt algorithm")
+	ErrNoSigningKey         = errors.New("no jwt signing key available")
+)
+
+// jwtKey は署名・検証に使う鍵をデコード済みの形で保持する。
+type jwtKey struct {
+	kid       string
+	algorithm string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type jwtKeyset struct {
+	mu         sync.RWMutex
+	active     *jwtKey
+	verifiers  map[string]*jwtKey
+	loadedAt   time.Time
+	lastMissAt time.Time
+}
+
+var keyset = &jwtKeyset{verifiers: map[string]*jwtKey{}}
+
+// decodeJWTKey はDBのレコードを署名・検証用の鍵に変換する。
+// legacy の鍵は JWT_SECRET から作り、JWT_SECRET が未設定の場合は nil を返す。
+func decodeJWTKey(k models.JWTKey) (*jwtKey, error) {
+	if k.Kid == LegacyJWTKid {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, nil
+		}
+		return &jwtKey{kid: k.Kid, algorithm: jwt.SigningMethodHS256.Alg(), signKey: []byte(secret), verifyKey: []byte(secret)}, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(k.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("decode key %s: %w", k.Kid, err)
+	}
+
+	switch k.Algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		return &jwtKey{kid: k.Kid, algorithm: k.Algorithm, signKey: raw, verifyKey: raw}, nil
+	case jwt.SigningMethodEdDSA.Alg():
+		if len(raw) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid ed25519 seed size for key %s", k.Kid)
+		}
+		priv := ed25519.NewKeyFromSeed(raw)
+		return &jwtKey{kid: k.Kid, algorithm: k.Algorithm, signKey: priv, verifyKey: priv.Public()}, nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// RegisterLegacyJWTKey は JWT_SECRET が設定されていれば legacy の鍵として鍵セットに登録する（登録済みなら何もしない）。
+// 他に鍵が無ければ active、あれば previous として登録し、RetireJWTKey で退役させると kid の無いトークンは検証されなくなる。
+func RegisterLegacyJWTKey() error {
+	if os.Getenv("JWT_SECRET") == "" {
+		return nil
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.JWTKey{}).Where("kid = ?", LegacyJWTKid).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		var active int64
+		if err := tx.Model(&models.JWTKey{}).Where("status = ?", models.JWTKeyStatusActive).Count(&active).Error; err != nil {
+			return err
+		}
+		status := models.JWTKeyStatusActive
+		if active > 0 {
+			status = models.JWTKeyStatusPrevious
+		}
+		return tx.Create(&models.JWTKey{Kid: LegacyJWTKid, Algorithm: jwt.SigningMethodHS256.Alg(), Secret: "-", Status: status}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return keyset.reload()
+}
+
+// reload はDBから有効な鍵（active / previous）を読み込み直す。
+func (ks *jwtKeyset) reload() error {
+	var keys []models.JWTKey
+	if err := config.DB.Where("status IN ?", []string{models.JWTKeyStatusActive, models.JWTKeyStatusPrevious}).
+		Find(&keys).Error; err != nil {
+		return err
+	}
+
+	verifiers := make(map[string]*jwtKey, len(keys))
+	var active *jwtKey
+	for _, k := range keys {
+		decoded, err := decodeJWTKey(k)
+		if err != nil {
+			return err
+		}
+		if decoded == nil {
+			// JWT_SECRET が未設定のため legacy の鍵は使えない
+			continue
+		}
+		verifiers[k.Kid] = decoded
+		if k.Status == models.JWTKeyStatusActive {
+			active = decoded
+		}
+	}
+
+	ks.mu.Lock()
+	ks.active = active
+	ks.verifiers = verifiers
+	ks.loadedAt = time.Now()
+	ks.mu.Unlock()
+	return nil
+}
+
+func (ks *jwtKeyset) refreshIfStale() error {
+	ks.mu.RLock()
+	stale := time.Since(ks.loadedAt) > jwtKeysetRefreshInterval
+	ks.mu.RUnlock()
+	if !stale {
+		return nil
+	}
+	return ks.reload()
+}
+
+func (ks *jwtKeyset) signingKey() (*jwtKey, error) {
+	if err := ks.refreshIfStale(); err != nil {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	active := ks.active
+	ks.mu.RUnlock()
+	if active != nil {
+		return active, nil
+	}
+	return nil, ErrNoSigningKey
+}
+
+func (ks *jwtKeyset) verificationKey(kid string) (*jwtKey, error) {
+	// kid の無いトークンはローテーション導入前に JWT_SECRET で署名されたもの。legacy の鍵が退役していれば検証しない
+	if kid == "" {
+		kid = LegacyJWTKid
+	}
+
+	if err := ks.refreshIfStale(); err != nil {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	key, ok := ks.verifiers[kid]
+	canRetry := time.Since(ks.lastMissAt) > jwtKeysetMissRefreshInterval
+	ks.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !canRetry {
+		return nil, ErrJWTKeyNotFound
+	}
+
+	// 別プロセスでローテーションされた直後の可能性があるため一度だけ読み直す
+	ks.mu.Lock()
+	ks.lastMissAt = time.Now()
+	ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, ok := ks.verifiers[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrJWTKeyNotFound
+}
+
+// SignAuthToken はアクティブな鍵でクレームに署名し、ヘッダーに kid をセットする。
+func SignAuthToken(claims jwt.MapClaims) (string, error) {
+	key, err := keyset.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+	if key.kid != "" {
+		token.Header["kid"] = key.kid
+	}
+	return token.SignedString(key.signKey)
+}
+
+// ParseAuthToken はヘッダーの kid に対応する鍵でトークンを検証する。
+// kid の無いトークンはローテーション導入前に発行されたものとして legacy の鍵（JWT_SECRET）で検証する。
+func ParseAuthToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := keyset.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// 鍵ごとのアルゴリズムと一致しないトークンは拒否する（alg すり替え対策）
+		if token.Method.Alg() != key.algorithm {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+}
+
+// HasJWTSigningKey は新規トークンを署名できる鍵が存在するかを返す。
+func HasJWTSigningKey() bool {
+	_, err := keyset.signingKey()
+	return err == nil
+}
+
+// generateJWTKey は指定アルゴリズムの新しい鍵レコードを生成する。
+func generateJWTKey(algorithm string) (models.JWTKey, error) {
+	var secret []byte
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		secret = make([]byte, 64)
+	case jwt.SigningMethodEdDSA.Alg():
+		secret = make([]byte, ed25519.SeedSize)
+	default:
+		return models.JWTKey{}, ErrUnsupportedAlgorithm
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return models.JWTKey{}, err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return models.JWTKey{}, err
+	}
+
+	return models.JWTKey{
+		Kid:       time.Now().Format("20060102") + "-" + hex.EncodeToString(kidBytes),
+		Algorithm: algorithm,
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		Status:    models.JWTKeyStatusActive,
+	}, nil
+}
+
+// RotateJWTKey は新しい鍵を生成してアクティブにし、既存のアクティブ鍵を previous に降格する。
+// previous の鍵は RetireJWTKey で退役させるまで発行済みトークンの検証に使われる。
+func RotateJWTKey(algorithm string) (models.JWTKey, error) {
+	newKey, err := generateJWTKey(algorithm)
+	if err != nil {
+		return models.JWTKey{}, err
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.JWTKey{}).
+			Where("status = ?", models.JWTKeyStatusActive).
+			Update("status", models.JWTKeyStatusPrevious).Error; err != nil {
+			return err
+		}
+		return tx.Create(&newKey).Error
+	})
+	if err != nil {
+		return models.JWTKey{}, err
+	}
+
+	if err := keyset.reload(); err != nil {
+		return models.JWTKey{}, err
+	}
+	return newKey, nil
+}
+
+// RetireJWTKey は previous 状態の鍵を退役させ、その鍵で署名されたトークンを無効にする。
+// アクティブな鍵は退役できない（先にローテーションすること）。
+func RetireJWTKey(kid string) error {
+	now := time.Now()
+	result := config.DB.Model(&models.JWTKey{}).
+		Where("kid = ? AND status = ?", kid, models.JWTKeyStatusPrevious).
+		Updates(map[string]interface{}{"status": models.JWTKeyStatusRetired, "retired_at": &now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrJWTKeyNotFound
+	}
+	return keyset.reload()
+}
+
+// ListJWTKeys は登録済みの鍵を新しい順に返す。
+func ListJWTKeys() ([]models.JWTKey, error) {
+	var keys []models.JWTKey
+	err := config.DB.Order("created_at desc").Find(&keys).Error
+	return keys, err
+}
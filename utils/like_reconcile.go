@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// 1回の照合で読み込む記事の件数
+const likeReconcileBatchSize = 500
+
+// 結果に含める不一致の最大件数（件数自体は Mismatched に全て数える）
+const maxReportedDiscrepancies = 1000
+
+// like_count を数え直すときの条件（取り消されていない、ボットでない従来のいいね）
+const countedLikesCondition = "likes.deleted_at IS NULL AND likes.is_bot = FALSE AND likes.reaction = ?"
+
+var ErrReconcileRunning = errors.New("like count reconciliation is already running")
+
+// 照合を同時に1つだけ実行するためのロック
+var likeReconcileMu sync.Mutex
+
+// LikeDiscrepancy は like_count と実際のいいね数が一致しない記事1件分
+type LikeDiscrepancy struct {
+	ArticleID string `json:"article_id"`
+	Title     string `json:"title"`
+	Stored    int    `json:"stored"` // articles.like_count
+	Actual    int64  `json:"actual"` // likes から数えた件数
+}
+
+// LikeReconcileResult は like_count の照合結果
+type LikeReconcileResult struct {
+	DryRun        bool              `json:"dry_run"`
+	Checked       int64             `json:"checked"`    // 照合した記事数
+	Mismatched    int64             `json:"mismatched"` // 不一致だった記事数
+	Fixed         int64             `json:"fixed"`      // 修正した記事数（dry run の場合は0）
+	Discrepancies []LikeDiscrepancy `json:"discrepancies"`
+	Truncated     bool              `json:"truncated"` // 不一致が多く、一部だけを返している
+	StartedAt     time.Time         `json:"started_at"`
+	FinishedAt    time.Time         `json:"finished_at"`
+}
+
+// ReconcileLikeCounts は記事の like_count を likes の件数と照合し、不一致を報告する。
+// dryRun が false の場合は不一致の記事を likeReconcileBatchSize 件ずつ数え直して修正する。
+// articleID を指定した場合はその記事だけを対象にする。
+func ReconcileLikeCounts(dryRun bool, articleID string) (LikeReconcileResult, error) {
+	if !likeReconcileMu.TryLock() {
+		return LikeReconcileResult{}, ErrReconcileRunning
+	}
+	defer likeReconcileMu.Unlock()
+
+	result := LikeReconcileResult{DryRun: dryRun, Discrepancies: []LikeDiscrepancy{}, StartedAt: time.Now()}
+	lastID := ""
+	for {
+		var rows []struct {
+			ID        string
+			Title     string
+			LikeCount int
+			Actual    int64
+		}
+		query := config.DB.Table("articles").
+			Select("articles.id, articles.title, articles.like_count, "+
+				"(SELECT COUNT(*) FROM likes WHERE likes.article_id = articles.id AND "+countedLikesCondition+") AS actual", models.DefaultReaction).
+			Where("articles.deleted_at IS NULL AND articles.id > ?", lastID).
+			Order("articles.id").
+			Limit(likeReconcileBatchSize)
+		if articleID != "" {
+			query = query.Where("articles.id = ?", articleID)
+		}
+		if err := query.Scan(&rows).Error; err != nil {
+			return result, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		lastID = rows[len(rows)-1].ID
+		result.Checked += int64(len(rows))
+
+		var mismatched []string
+		for _, r := range rows {
+			if int64(r.LikeCount) == r.Actual {
+				continue
+			}
+			mismatched = append(mismatched, r.ID)
+			result.Mismatched++
+			if len(result.Discrepancies) < maxReportedDiscrepancies {
+				result.Discrepancies = append(result.Discrepancies, LikeDiscrepancy{
+					ArticleID: r.ID, Title: r.Title, Stored: r.LikeCount, Actual: r.Actual,
+				})
+			} else {
+				result.Truncated = true
+			}
+		}
+
+		if !dryRun && len(mismatched) > 0 {
+			// 照合中に付け外しされたいいねも反映されるよう、読み込んだ値ではなく UPDATE の中で数え直す
+			fixed := config.DB.Exec("UPDATE articles SET like_count = "+
+				"(SELECT COUNT(*) FROM likes WHERE likes.article_id = articles.id AND "+countedLikesCondition+") "+
+				"WHERE id IN ?", models.DefaultReaction, mismatched)
+			if fixed.Error != nil {
+				return result, fixed.Error
+			}
+			result.Fixed += fixed.RowsAffected
+		}
+
+		if len(rows) < likeReconcileBatchSize {
+			break
+		}
+	}
+
+	result.FinishedAt = time.Now()
+	return result, nil
+}
+
+// StartLikeReconciliation は LIKE_RECONCILE_INTERVAL（デフォルト24時間）ごとに全記事の like_count を照合し、
+// 不一致があれば修正してログに記録する。0以下を指定した場合は定期実行しない。
+func StartLikeReconciliation() {
+	interval := config.GetEnvDuration("LIKE_RECONCILE_INTERVAL", 24*time.Hour)
+	if interval <= 0 {
+		log.Println("[Likes] LIKE_RECONCILE_INTERVAL が0以下のため、いいね数の定期照合は行いません。")
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			result, err := ReconcileLikeCounts(false, "")
+			if errors.Is(err, ErrReconcileRunning) {
+				continue
+			}
+			if err != nil {
+				log.Printf("[Likes] いいね数の照合に失敗: %v", err)
+				continue
+			}
+			for _, d := range result.Discrepancies {
+				log.Printf("[Likes] like_count を修正しました: article_id=%s %d -> %d", d.ArticleID, d.Stored, d.Actual)
+			}
+			if result.Mismatched > 0 {
+				log.Printf("[Likes] いいね数を照合しました: 記事=%d 不一致=%d 修正=%d", result.Checked, result.Mismatched, result.Fixed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"k-cms/config"
+	"time"
+)
+
+// LoginLockoutThreshold はアカウントをロックするまでの連続失敗回数を返す。
+func LoginLockoutThreshold() int {
+	return config.GetEnvInt("LOGIN_LOCKOUT_THRESHOLD", 5)
+}
+
+// LoginLockoutDuration は連続失敗回数に応じたロック時間を返す。
+// しきい値に達するまでは 0、以降は失敗のたびに LOGIN_LOCKOUT_BASE から倍々で伸び、
+// LOGIN_LOCKOUT_MAX で頭打ちになる（指数バックオフ）。
+func LoginLockoutDuration(failedCount int) time.Duration {
+	threshold := LoginLockoutThreshold()
+	if failedCount < threshold {
+		return 0
+	}
+
+	base := config.GetEnvDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	max := config.GetEnvDuration("LOGIN_LOCKOUT_MAX", 24*time.Hour)
+
+	d := base
+	for i := threshold; i < failedCount; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
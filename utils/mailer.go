@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"k-cms/config"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailMessage は送信するメール1通分の内容
+type MailMessage struct {
+	To      []string
+	Subject string
+	Body    string // text/plain
+}
+
+// Mailer はメール送信の実装を差し替えるためのインターフェース
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+var (
+	mailer     Mailer
+	mailerOnce sync.Once
+	mailerMu   sync.RWMutex
+)
+
+// GetMailer は環境変数から構成したメーラーを返す。
+// SMTP_HOST が未設定の場合はログ出力のみを行う LogMailer を返す。
+func GetMailer() Mailer {
+	mailerOnce.Do(func() {
+		mailerMu.Lock()
+		defer mailerMu.Unlock()
+		if mailer != nil {
+			return
+		}
+		if host := config.GetEnv("SMTP_HOST", ""); host != "" {
+			mailer = NewSMTPMailerFromEnv()
+		} else {
+			log.Println("[Mail] SMTP_HOST環境変数が設定されていません。メールはログに出力されます。")
+			mailer = LogMailer{}
+		}
+	})
+
+	mailerMu.RLock()
+	defer mailerMu.RUnlock()
+	return mailer
+}
+
+// SetMailer はメーラーの実装を差し替える。
+func SetMailer(m Mailer) {
+	mailerOnce.Do(func() {})
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+}
+
+// LogMailer はメールを送信せずにログへ出力する（開発環境用）
+type LogMailer struct{}
+
+func (LogMailer) Send(msg MailMessage) error {
+	log.Printf("[Mail] To=%s Subject=%s\n%s", strings.Join(msg.To, ","), msg.Subject, msg.Body)
+	return nil
+}
+
+// SMTPMailer は SMTP サーバー経由でメールを送信する。
+// Username が空の場合は認証なしで送信するため、MailHog などのローカルSMTPキャッチャーでも動作する。
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+// NewSMTPMailerFromEnv は SMTP_* 環境変数から SMTPMailer を構成する。
+func NewSMTPMailerFromEnv() *SMTPMailer {
+	return &SMTPMailer{
+		Host:     config.GetEnv("SMTP_HOST", "localhost"),
+		Port:     config.GetEnv("SMTP_PORT", "25"),
+		Username: config.GetEnv("SMTP_USERNAME", ""),
+		Password: config.GetEnv("SMTP_PASSWORD", ""),
+		From:     config.GetEnv("SMTP_FROM", "no-reply@katori.dev"),
+		Timeout:  config.GetEnvDuration("SMTP_TIMEOUT", 10*time.Second),
+	}
+}
+
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("smtp: no recipients")
+	}
+
+	addr := net.JoinHostPort(m.Host, m.Port)
+	conn, err := net.DialTimeout("tcp", addr, m.Timeout)
+	if err != nil {
+		return fmt.Errorf("smtp: dial %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(m.Timeout))
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer client.Close()
+
+	// サーバーが対応していれば STARTTLS を使用する
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(tlsConfigFor(m.Host)); err != nil {
+			return fmt.Errorf("smtp: starttls: %w", err)
+		}
+	}
+
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return fmt.Errorf("smtp: auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(m.From); err != nil {
+		return fmt.Errorf("smtp: mail from: %w", err)
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp: rcpt %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp: data: %w", err)
+	}
+	if _, err := w.Write(buildMailBody(m.From, msg)); err != nil {
+		w.Close()
+		return fmt.Errorf("smtp: write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp: data close: %w", err)
+	}
+	return client.Quit()
+}
+
+// buildMailBody はヘッダー付きのメール本文（RFC 5322）を組み立てる。
+func buildMailBody(from string, msg MailMessage) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+func tlsConfigFor(host string) *tls.Config {
+	return &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+}
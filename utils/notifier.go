@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Notifier は通知チャネル（Slack, Discord, Webhook, メールなど）のインターフェース
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n BuildNotification) error
+}
+
+// permanentNotifyError はリトライしても成功しない失敗（4xx など）を表す
+type permanentNotifyError struct {
+	err error
+}
+
+func (e *permanentNotifyError) Error() string { return e.err.Error() }
+func (e *permanentNotifyError) Unwrap() error { return e.err }
+
+var notifyHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON は JSON を POST し、2xx 以外をエラーとして返す。
+// 4xx（429 を除く）は再送しても成功しないため permanentNotifyError にする。
+func postJSON(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentNotifyError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "k-cms-notifier")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentNotifyError{err}
+	}
+	return err
+}
+
+// SlackNotifier は Slack の Incoming Webhook に通知する
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (s *SlackNotifier) Name() string { return "slack" }
+
+func (s *SlackNotifier) Notify(ctx context.Context, n BuildNotification) error {
+	body, err := json.Marshal(map[string]string{"text": n.Text(true)})
+	if err != nil {
+		return &permanentNotifyError{err}
+	}
+	return postJSON(ctx, s.WebhookURL, body, nil)
+}
+
+// Discord のメッセージ本文の上限
+const discordContentLimit = 2000
+
+// DiscordNotifier は Discord の Webhook に通知する
+type DiscordNotifier struct {
+	WebhookURL string
+}
+
+func (d *DiscordNotifier) Name() string { return "discord" }
+
+func (d *DiscordNotifier) Notify(ctx context.Context, n BuildNotification) error {
+	text := n.Text(true)
+	if len([]rune(text)) > discordContentLimit {
+		// ログの途中で切れるとコードブロックが閉じないため、ログ部分を省いた本文にする
+		text = n.Text(false)
+		if r := []rune(text); len(r) > discordContentLimit {
+			text = string(r[:discordContentLimit])
+		}
+	}
+	body, err := json.Marshal(map[string]string{"content": text})
+	if err != nil {
+		return &permanentNotifyError{err}
+	}
+	return postJSON(ctx, d.WebhookURL, body, nil)
+}
+
+// WebhookNotifier は汎用の JSON Webhook に BuildNotification をそのまま送信する。
+// Secret が設定されている場合は "タイムスタンプ.本文" の HMAC-SHA256 を署名ヘッダーに付与する。
+//
+//	X-KCMS-Timestamp: UNIX 秒
+//	X-KCMS-Signature: sha256=<hex>
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n BuildNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return &permanentNotifyError{err}
+	}
+
+	header := http.Header{}
+	header.Set("X-KCMS-Event", n.Event)
+	if w.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set("X-KCMS-Timestamp", ts)
+		header.Set("X-KCMS-Signature", "sha256="+SignWebhookPayload(w.Secret, ts, body))
+	}
+	return postJSON(ctx, w.URL, body, header)
+}
+
+// SignWebhookPayload は Webhook の署名を計算する。受信側の検証にも同じ計算を用いる。
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EmailNotifier はメールで通知する（送信には GetMailer のメーラーを使用する）
+type EmailNotifier struct {
+	To []string
+}
+
+func (e *EmailNotifier) Name() string { return "email" }
+
+func (e *EmailNotifier) Notify(ctx context.Context, n BuildNotification) error {
+	return GetMailer().Send(MailMessage{
+		To:      e.To,
+		Subject: "[k-cms] " + n.Title(),
+		Body:    n.Text(true),
+	})
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"k-cms/config"
+	"k-cms/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// receivedWebhook はテスト用の受信サーバーが受け取ったリクエスト
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver は Webhook を受け取って記録するローカルの受信サーバー。
+// statuses を指定した場合は、受信ごとに先頭から順にそのステータスを返す（使い切った後は 200）。
+type webhookReceiver struct {
+	srv      *httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	received chan receivedWebhook
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+	r := &webhookReceiver{statuses: statuses, received: make(chan receivedWebhook, 16)}
+	r.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.received <- receivedWebhook{header: req.Header.Clone(), body: body}
+
+		r.mu.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+// next は次の受信を待つ。timeout までに届かなければ ok=false を返す。
+func (r *webhookReceiver) next(timeout time.Duration) (receivedWebhook, bool) {
+	select {
+	case got := <-r.received:
+		return got, true
+	case <-time.After(timeout):
+		return receivedWebhook{}, false
+	}
+}
+
+// useNotifySettings は通知チャネルを SetNotifiers で差し替え、通知するイベントと再送の設定をテスト用の値にする。
+func useNotifySettings(t *testing.T, events []string, retries int, backoff time.Duration, notifiers ...Notifier) {
+	t.Helper()
+	prev := getNotifySettings()
+	SetNotifiers(notifiers...)
+
+	notifyConfigMu.Lock()
+	s := *notifyConfig
+	s.events = map[string]bool{}
+	for _, ev := range events {
+		s.events[ev] = true
+	}
+	s.retries = retries
+	s.backoff = backoff
+	s.logLines = 0
+	notifyConfig = &s
+	notifyConfigMu.Unlock()
+
+	t.Cleanup(func() {
+		notifyConfigMu.Lock()
+		notifyConfig = prev
+		notifyConfigMu.Unlock()
+	})
+}
+
+func testNotification() BuildNotification {
+	return BuildNotification{
+		Event:      NotifyEventFailure,
+		BuildID:    models.NewUUIDv7().String(),
+		State:      string(BuildStateFailed),
+		Source:     "article",
+		Action:     "update",
+		ArticleIDs: []string{"a1"},
+		Error:      "Exit code: 1",
+		LogTail:    []string{"npm ERR!"},
+	}
+}
+
+func TestWebhookNotifierSignature(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	const secret = "webhook-secret"
+	n := testNotification()
+
+	if err := notifyWithRetry(&WebhookNotifier{URL: receiver.srv.URL, Secret: secret}, n, 0, 0); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	got, ok := receiver.next(time.Second)
+	if !ok {
+		t.Fatal("webhook was not received")
+	}
+
+	// 受信側と同じ手順（"タイムスタンプ.本文" の HMAC-SHA256）で検証する
+	ts := got.header.Get("X-KCMS-Timestamp")
+	if ts == "" {
+		t.Fatal("X-KCMS-Timestamp header is missing")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(got.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if sig := got.header.Get("X-KCMS-Signature"); !hmac.Equal([]byte(sig), []byte(want)) {
+		t.Errorf("X-KCMS-Signature = %q, want %q", sig, want)
+	}
+	if ev := got.header.Get("X-KCMS-Event"); ev != NotifyEventFailure {
+		t.Errorf("X-KCMS-Event = %q, want %q", ev, NotifyEventFailure)
+	}
+
+	var payload BuildNotification
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if payload.BuildID != n.BuildID || payload.Error != n.Error {
+		t.Errorf("payload = %+v, want build_id=%s error=%q", payload, n.BuildID, n.Error)
+	}
+}
+
+func TestWebhookNotifierWithoutSecretIsUnsigned(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	if err := notifyWithRetry(&WebhookNotifier{URL: receiver.srv.URL}, testNotification(), 0, 0); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	got, ok := receiver.next(time.Second)
+	if !ok {
+		t.Fatal("webhook was not received")
+	}
+	if sig := got.header.Get("X-KCMS-Signature"); sig != "" {
+		t.Errorf("X-KCMS-Signature = %q, want none", sig)
+	}
+}
+
+func TestNotifyRetriesWithBackoff(t *testing.T) {
+	t.Run("5xx は指数バックオフで再送する", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+		backoff := 20 * time.Millisecond
+
+		start := time.Now()
+		err := notifyWithRetry(&WebhookNotifier{URL: receiver.srv.URL}, testNotification(), 3, backoff)
+		elapsed := time.Since(start)
+
+		if err != nil {
+			t.Fatalf("notify: %v", err)
+		}
+		if n := len(receiver.received); n != 3 {
+			t.Errorf("attempts = %d, want 3", n)
+		}
+		// 1回目の失敗後に backoff、2回目の失敗後に backoff*2 待つ
+		if min := backoff + 2*backoff; elapsed < min {
+			t.Errorf("elapsed = %v, want at least %v", elapsed, min)
+		}
+	})
+
+	t.Run("再送回数を超えたらエラーを返す", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+		err := notifyWithRetry(&WebhookNotifier{URL: receiver.srv.URL}, testNotification(), 2, time.Millisecond)
+		if err == nil {
+			t.Fatal("notify succeeded, want error")
+		}
+		if n := len(receiver.received); n != 3 {
+			t.Errorf("attempts = %d, want 3", n)
+		}
+	})
+
+	t.Run("4xx は再送しない", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, http.StatusBadRequest)
+		err := notifyWithRetry(&WebhookNotifier{URL: receiver.srv.URL}, testNotification(), 3, time.Millisecond)
+		if err == nil {
+			t.Fatal("notify succeeded, want error")
+		}
+		if n := len(receiver.received); n != 1 {
+			t.Errorf("attempts = %d, want 1", n)
+		}
+	})
+}
+
+// setupNotifyTestDB はビルド履歴を保存するテスト用の DB を用意する。
+func setupNotifyTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Build{}, &models.BuildLogLine{}); err != nil {
+		t.Fatalf("migrate test db: %v", err)
+	}
+	prevDB := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = prevDB })
+}
+
+// createFinishedBuild は終了済みのビルド履歴を作成する。
+func createFinishedBuild(t *testing.T, state string, finishedAt time.Time) models.Build {
+	t.Helper()
+	started := finishedAt.Add(-time.Minute)
+	build := models.Build{
+		TriggerSource: "article",
+		Action:        "update",
+		ArticleIDs:    models.StringArray{},
+		State:         state,
+		QueuedAt:      started,
+		StartedAt:     &started,
+		FinishedAt:    &finishedAt,
+	}
+	if err := config.DB.Create(&build).Error; err != nil {
+		t.Fatalf("create build: %v", err)
+	}
+	return build
+}
+
+func TestNotifyBuildFinishedEventFilter(t *testing.T) {
+	setupNotifyTestDB(t)
+	base := time.Now().Add(-time.Hour)
+	failed := createFinishedBuild(t, models.BuildStateFailed, base)
+	recovered := createFinishedBuild(t, models.BuildStateSuccess, base.Add(time.Minute))
+	succeeded := createFinishedBuild(t, models.BuildStateSuccess, base.Add(2*time.Minute))
+
+	tests := []struct {
+		name   string
+		events []string
+		build  models.Build
+		state  BuildState
+		want   string // 空の場合は通知されないこと
+	}{
+		{name: "失敗は failure", events: []string{NotifyEventFailure, NotifyEventRecovery}, build: failed, state: BuildStateFailed, want: NotifyEventFailure},
+		{name: "失敗の後の成功は recovery", events: []string{NotifyEventFailure, NotifyEventRecovery}, build: recovered, state: BuildStateSuccess, want: NotifyEventRecovery},
+		{name: "成功の後の成功はデフォルトでは通知しない", events: []string{NotifyEventFailure, NotifyEventRecovery}, build: succeeded, state: BuildStateSuccess},
+		{name: "success を指定すれば成功の後の成功も通知する", events: []string{NotifyEventSuccess}, build: succeeded, state: BuildStateSuccess, want: NotifyEventSuccess},
+		{name: "recovery を含めなければ復旧は通知しない", events: []string{NotifyEventFailure, NotifyEventSuccess}, build: recovered, state: BuildStateSuccess},
+		{name: "キャンセルは通知しない", events: []string{NotifyEventFailure, NotifyEventRecovery, NotifyEventSuccess}, build: failed, state: BuildStateCanceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newWebhookReceiver(t)
+			useNotifySettings(t, tt.events, 0, 0, &WebhookNotifier{URL: receiver.srv.URL})
+
+			notifyBuildFinished(tt.build.ID.String(), tt.state)
+
+			got, ok := receiver.next(500 * time.Millisecond)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("notified %q, want no notification", got.header.Get("X-KCMS-Event"))
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("no notification, want %q", tt.want)
+			}
+			if ev := got.header.Get("X-KCMS-Event"); ev != tt.want {
+				t.Errorf("event = %q, want %q", ev, tt.want)
+			}
+			var payload BuildNotification
+			if err := json.Unmarshal(got.body, &payload); err != nil {
+				t.Fatalf("invalid payload: %v", err)
+			}
+			if payload.BuildID != tt.build.ID.String() {
+				t.Errorf("build_id = %q, want %q", payload.BuildID, tt.build.ID)
+			}
+		})
+	}
+}
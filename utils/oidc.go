@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrOIDCNotConfigured = errors.New("oidc is not configured")
+
+// OIDCClient は IDプロバイダのディスカバリ結果と OAuth2 クライアント設定をまとめたもの
+type OIDCClient struct {
+	Provider *oidc.Provider
+	OAuth2   oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+	Issuer   string
+}
+
+var (
+	oidcClient   *OIDCClient
+	oidcClientMu sync.Mutex
+)
+
+// OIDCEnabled は OIDC ログインが設定されているかを返す。
+func OIDCEnabled() bool {
+	return config.GetEnv("OIDC_ISSUER_URL", "") != "" && config.GetEnv("OIDC_CLIENT_ID", "") != ""
+}
+
+// OIDCDefaultRole は JIT プロビジョニングで作成するユーザーの権限（OIDC_DEFAULT_ROLE、デフォルトは editor）を返す。
+// 定義されていない権限の場合はエラーを返す。
+func OIDCDefaultRole() (string, error) {
+	role := config.GetEnv("OIDC_DEFAULT_ROLE", models.RoleEditor)
+	if !models.IsValidRole(role) {
+		return "", fmt.Errorf("OIDC_DEFAULT_ROLE must be %q or %q (got %q)", models.RoleAdmin, models.RoleEditor, role)
+	}
+	return role, nil
+}
+
+// GetOIDCClient は OIDC_* 環境変数からクライアントを構成して返す。
+// ディスカバリに失敗した場合はキャッシュせず、次回呼び出し時に再試行する。
+func GetOIDCClient(ctx context.Context) (*OIDCClient, error) {
+	if !OIDCEnabled() {
+		return nil, ErrOIDCNotConfigured
+	}
+
+	oidcClientMu.Lock()
+	defer oidcClientMu.Unlock()
+	if oidcClient != nil {
+		return oidcClient, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	issuer := config.GetEnv("OIDC_ISSUER_URL", "")
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := config.GetEnv("OIDC_CLIENT_ID", "")
+	oidcClient = &OIDCClient{
+		Provider: provider,
+		OAuth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: config.GetEnv("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  config.GetEnv("OIDC_REDIRECT_URL", "https://www.katori.dev/api/oidc/callback"),
+			Endpoint:     provider.Endpoint(),
+			Scopes:       strings.Fields(config.GetEnv("OIDC_SCOPES", "openid email profile")),
+		},
+		Verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		Issuer:   issuer,
+	}
+	return oidcClient, nil
+}
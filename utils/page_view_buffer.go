@@ -0,0 +1,316 @@
+package utils
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pageViewKey は PV を1件として数える単位（page_views のユニーク制約と同じ）
+type pageViewKey struct {
+	articleID   string
+	fingerprint string
+	date        string
+}
+
+type cachedViewCount struct {
+	stored    int64 // ArticleViewCount で数えた件数（DB に保存済みの分）
+	expiresAt time.Time
+}
+
+// pageViewBuffer は PV をメモリ上で重複排除して溜めておき、一定間隔でまとめて INSERT する。
+// 記事がアクセスの集中するページに載った際に、1リクエストごとの SELECT / INSERT / COUNT(*) で DB が詰まらないようにするため。
+type pageViewBuffer struct {
+	mu      sync.Mutex
+	pending map[pageViewKey]models.PageView
+	// 書き出し待ち・書き出し中の人間のアクセスの件数（記事ごと、DB に保存が確定するまで PV 数に加える）
+	unsaved map[string]int64
+	// 今日受け付けた PV（同じ日の再訪問をメモリ上で弾く）。値はボットとして受け付けたかどうか
+	seen     map[pageViewKey]bool
+	seenDate string
+	counts   map[string]cachedViewCount
+	started  bool
+	wake     chan struct{}
+	stats    PageViewBufferStats
+
+	// flushMu は書き出し中の行が「書き出し待ちにも DB にも見えない」瞬間に
+	// PV 数の再計算や fingerprint のデータ削除が行われないようにするためのロック
+	flushMu sync.RWMutex
+}
+
+// PageViewBufferStats は PV の書き出し待ちの状態と累計の件数
+type PageViewBufferStats struct {
+	Pending           int       `json:"pending"`    // 書き出し待ちの件数（キューの深さ）
+	SeenToday         int       `json:"seen_today"` // 今日受け付けた件数（メモリ上の重複排除に使う）
+	Accepted          int64     `json:"accepted"`
+	Duplicates        int64     `json:"duplicates"`    // メモリ上で重複として弾いた件数
+	Upgraded          int64     `json:"upgraded"`      // ボットとして受け付けた後に人間のアクセスとして記録し直した件数
+	Dropped           int64     `json:"dropped"`       // 書き出し待ちが上限に達して記録できなかった件数
+	Written           int64     `json:"written"`       // DB に書き出した件数
+	RowsAffected      int64     `json:"rows_affected"` // MySQL の数え方（挿入は1件、既存の行の書き換えは2件、変更無しは0件）での変更行数
+	FlushErrors       int64     `json:"flush_errors"`
+	LastFlushAt       time.Time `json:"last_flush_at"`
+	LastFlushDuration string    `json:"last_flush_duration"`
+	LastFlushRows     int       `json:"last_flush_rows"`
+	LastError         string    `json:"last_error,omitempty"`
+}
+
+var pageViews = &pageViewBuffer{
+	pending: map[pageViewKey]models.PageView{},
+	unsaved: map[string]int64{},
+	seen:    map[pageViewKey]bool{},
+	counts:  map[string]cachedViewCount{},
+	wake:    make(chan struct{}, 1),
+}
+
+// pageViewBufferSettings は書き出し間隔（PAGEVIEW_FLUSH_INTERVAL）、1回の INSERT の件数（PAGEVIEW_FLUSH_BATCH）、
+// 書き出し待ちの上限（PAGEVIEW_BUFFER_MAX）を返す。
+func pageViewBufferSettings() (interval time.Duration, batch, max int) {
+	return config.GetEnvDuration("PAGEVIEW_FLUSH_INTERVAL", 2*time.Second),
+		config.GetEnvInt("PAGEVIEW_FLUSH_BATCH", 500),
+		config.GetEnvInt("PAGEVIEW_BUFFER_MAX", 100000)
+}
+
+// BufferPageView は PV を書き出し待ちに追加する。同じ記事・fingerprint・日付の PV を既に受け付けている場合は何もしない。
+// ただし、ボットとして受け付けた PV に人間のアクセスが届いた場合は人間のアクセスとして記録し直す
+// （ヘッドレスブラウザでの事前読み込みなどの後に、同じ fingerprint の閲覧者が数えられなくなるのを防ぐ）。
+// 新しく受け付けた人間のアクセス（PV 数が増えるもの）の場合に true を返す。
+func BufferPageView(view models.PageView) bool {
+	_, batch, max := pageViewBufferSettings()
+	key := pageViewKey{view.ArticleID.String(), view.Fingerprint, view.VisitedDate.Format(statsDateLayout)}
+
+	b := pageViews
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.started {
+		b.started = true
+		go b.loop()
+	}
+
+	if b.seenDate != key.date {
+		b.seen = map[pageViewKey]bool{}
+		b.seenDate = key.date
+	}
+	wasBot, ok := b.seen[key]
+	if ok && (!wasBot || view.IsBot) {
+		b.stats.Duplicates++
+		return false
+	}
+	upgrade := ok // ボットとして受け付けた PV を人間のアクセスに置き換える（保存済みの行は flush で書き換わる）
+	if _, pending := b.pending[key]; !pending && len(b.pending) >= max {
+		b.stats.Dropped++
+		if b.stats.Dropped%1000 == 1 {
+			log.Printf("[PageView] 書き出し待ちが上限(%d件)に達したため PV を記録できませんでした: 累計=%d件", max, b.stats.Dropped)
+		}
+		return false
+	}
+
+	b.seen[key] = view.IsBot
+	b.pending[key] = view
+	if upgrade {
+		b.stats.Upgraded++
+	} else {
+		b.stats.Accepted++
+	}
+	if !view.IsBot {
+		b.unsaved[key.articleID]++
+	}
+	if len(b.pending) >= batch {
+		// 次の定期実行を待たずに書き出す
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return !view.IsBot
+}
+
+// flush は書き出し待ちの PV をまとめて保存する。失敗した場合は書き出し待ちに戻す。
+// 同じ記事・fingerprint・日付の行が保存済みの場合は、保存済みの行がボットで新しい行が人間のアクセスの時だけ書き換える
+// （再起動でメモリ上の記録が失われた後でも、ボットの行を人間のアクセスとして数え直せるように）。
+func (b *pageViewBuffer) flush() error {
+	_, batchSize, _ := pageViewBufferSettings()
+
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := make([]models.PageView, 0, len(b.pending))
+	for _, v := range b.pending {
+		batch = append(batch, v)
+	}
+	b.pending = map[pageViewKey]models.PageView{}
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	result := config.DB.Clauses(pageViewUpsert).CreateInBatches(&batch, batchSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.LastFlushAt = start
+	b.stats.LastFlushDuration = time.Since(start).String()
+	b.stats.LastFlushRows = len(batch)
+
+	if result.Error != nil {
+		for _, v := range batch {
+			key := pageViewKey{v.ArticleID.String(), v.Fingerprint, v.VisitedDate.Format(statsDateLayout)}
+			if _, ok := b.pending[key]; !ok {
+				b.pending[key] = v
+			}
+		}
+		b.stats.FlushErrors++
+		b.stats.LastError = result.Error.Error()
+		return result.Error
+	}
+
+	for _, v := range batch {
+		articleID := v.ArticleID.String()
+		if !v.IsBot {
+			if b.unsaved[articleID]--; b.unsaved[articleID] <= 0 {
+				delete(b.unsaved, articleID)
+			}
+		}
+		// 保存済みの行と重複していると保存済みの件数が分からないため、次回は DB から数え直す
+		delete(b.counts, articleID)
+	}
+	b.stats.Written += int64(len(batch))
+	b.stats.RowsAffected += result.RowsAffected
+	b.stats.LastError = ""
+	return nil
+}
+
+// pageViewUpsert は (article_id, fingerprint, visited_date) が保存済みの場合の更新内容。
+// 保存済みの行がボットで新しい行が人間のアクセスの場合だけ、閲覧環境を人間のアクセスのものに書き換えて is_bot を下ろす。
+// MySQL は左から順に代入するため、is_bot は条件に使う他の列より後に更新する。
+var pageViewUpsert = func() clause.OnConflict {
+	upgrade := "page_views.is_bot AND NOT VALUES(is_bot)"
+	var set clause.Set
+	for _, column := range []string{"bot_reason", "ip_address", "user_agent", "referrer_host", "source",
+		"utm_source", "utm_medium", "utm_campaign", "language", "device_class", "browser"} {
+		set = append(set, clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value:  gorm.Expr("IF(" + upgrade + ", VALUES(" + column + "), page_views." + column + ")"),
+		})
+	}
+	set = append(set, clause.Assignment{
+		Column: clause.Column{Name: "is_bot"},
+		Value:  gorm.Expr("page_views.is_bot AND VALUES(is_bot)"),
+	})
+	return clause.OnConflict{DoUpdates: set}
+}()
+
+func (b *pageViewBuffer) loop() {
+	interval, _, _ := pageViewBufferSettings()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.wake:
+		}
+		if err := b.flush(); err != nil {
+			log.Printf("[PageView] PV の書き出しに失敗（次回再試行します）: %v", err)
+		}
+	}
+}
+
+// FlushPageViews は書き出し待ちの PV を直ちに保存する（日次集計の前やサーバーの終了時に呼ぶ）。
+func FlushPageViews() error {
+	return pageViews.flush()
+}
+
+// discardPendingPageViews は fingerprint の書き出し待ちの PV を破棄し、今日受け付けた記録からも取り除く。
+// 書き出し中のものは保存が終わるのを待つため、呼び出し後に DB から削除すれば残らない。
+func discardPendingPageViews(fingerprint string) {
+	b := pageViews
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, v := range b.pending {
+		if key.fingerprint != fingerprint {
+			continue
+		}
+		delete(b.pending, key)
+		delete(b.seen, key)
+		if !v.IsBot {
+			if b.unsaved[key.articleID]--; b.unsaved[key.articleID] <= 0 {
+				delete(b.unsaved, key.articleID)
+			}
+		}
+		delete(b.counts, key.articleID)
+	}
+	// 書き出し済みの PV も DB から削除されるため、同じ日の再訪問を重複として弾かないようにする
+	for key := range b.seen {
+		if key.fingerprint == fingerprint {
+			delete(b.seen, key)
+		}
+	}
+}
+
+// invalidateViewCounts は記事の保存済みの PV 数のキャッシュを破棄する（page_views の行を削除した後に呼ぶ）。
+// 数え直し中のものは終わるのを待つため、呼び出し後は削除後の件数が数えられる。
+func invalidateViewCounts(articleIDs []string) {
+	b := pageViews
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, articleID := range articleIDs {
+		delete(b.counts, articleID)
+	}
+}
+
+// CachedViewCount は記事の総PV数を返す。保存済みの件数は PAGEVIEW_COUNT_CACHE_TTL（デフォルト30秒）の間キャッシュし、
+// 書き出し待ちの件数を加える。キャッシュが切れている場合のみ ArticleViewCount で DB から数える。
+func CachedViewCount(articleID string) (int64, error) {
+	b := pageViews
+	now := time.Now()
+
+	b.mu.Lock()
+	if c, ok := b.counts[articleID]; ok && now.Before(c.expiresAt) {
+		count := c.stored + b.unsaved[articleID]
+		b.mu.Unlock()
+		return count, nil
+	}
+	b.mu.Unlock()
+
+	// 書き出し中は DB の件数と書き出し待ちの件数が食い違うため、書き出しの完了を待ってから数える
+	b.flushMu.RLock()
+	defer b.flushMu.RUnlock()
+
+	stored, err := ArticleViewCount(articleID)
+	if err != nil {
+		return 0, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ttl := config.GetEnvDuration("PAGEVIEW_COUNT_CACHE_TTL", 30*time.Second)
+	b.counts[articleID] = cachedViewCount{stored: stored, expiresAt: now.Add(ttl)}
+	return stored + b.unsaved[articleID], nil
+}
+
+// GetPageViewBufferStats は PV の書き出し待ちの件数などを返す。
+func GetPageViewBufferStats() PageViewBufferStats {
+	pageViews.mu.Lock()
+	defer pageViews.mu.Unlock()
+
+	stats := pageViews.stats
+	stats.Pending = len(pageViews.pending)
+	stats.SeenToday = len(pageViews.seen)
+	return stats
+}
@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"k-cms/config"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// bcrypt は先頭72バイトまでしかハッシュに使わない
+const bcryptMaxPasswordBytes = 72
+
+// Argon2id のパラメータのデフォルト値
+const (
+	defaultArgon2MemoryKiB   = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	defaultBcryptCost        = 12
+)
+
+// PasswordHasher はパスワードハッシュ方式ごとの実装
+type PasswordHasher interface {
+	// Hash はパスワードをハッシュ化し、パラメータを含むエンコード済み文字列を返す
+	Hash(password string) (string, error)
+	// Verify はハッシュとパスワードが一致するかを返す
+	Verify(encoded, password string) (bool, error)
+	// Handles はエンコード済み文字列がこの方式のものかを返す
+	Handles(encoded string) bool
+	// NeedsRehash は現在の設定で再ハッシュすべきかを返す
+	NeedsRehash(encoded string) bool
+}
+
+// bcryptHasher は従来の bcrypt ハッシュ（$2a$, $2b$, $2y$）
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hashed), err
+}
+
+func (h bcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h bcryptHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2")
+}
+
+func (h bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// argon2idHasher は Argon2id ハッシュ（PHC 形式: $argon2id$v=19$m=65536,t=3,p=2$salt$hash）
+type argon2idHasher struct {
+	memory      uint32 // KiB
+	iterations  uint32
+	parallelism uint8
+	saltLength  int
+	keyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, h.keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	p := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+	// t=0 / p=0 は argon2.IDKey が panic するため不正な形式として扱う
+	if p.memory == 0 || p.iterations == 0 || p.parallelism == 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(p.salt) == 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+	return p, nil
+}
+
+func (h argon2idHasher) Verify(encoded, password string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h argon2idHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.memory || p.iterations != h.iterations || p.parallelism != h.parallelism ||
+		uint32(len(p.key)) != h.keyLength
+}
+
+// passwordHashSetting はパスワードハッシュのパラメータの環境変数と許容範囲
+type passwordHashSetting struct {
+	key        string
+	defaultVal int
+	min, max   int
+}
+
+var passwordHashSettings = []passwordHashSetting{
+	{"ARGON2_MEMORY_KIB", defaultArgon2MemoryKiB, 8 * 1024, 4 * 1024 * 1024}, // 8MiB〜4GiB
+	{"ARGON2_ITERATIONS", defaultArgon2Iterations, 1, 100},
+	{"ARGON2_PARALLELISM", defaultArgon2Parallelism, 1, 255},
+	{"BCRYPT_COST", defaultBcryptCost, bcrypt.MinCost, bcrypt.MaxCost},
+}
+
+// value は環境変数の値を返す。範囲外の場合はエラーとデフォルト値を返す。
+func (s passwordHashSetting) value() (int, error) {
+	v := config.GetEnvInt(s.key, s.defaultVal)
+	if v < s.min || v > s.max {
+		return s.defaultVal, fmt.Errorf("%s must be between %d and %d (got %d)", s.key, s.min, s.max, v)
+	}
+	return v, nil
+}
+
+// CheckPasswordHashSettings はパスワードハッシュのパラメータが許容範囲内かを検証する（起動時に呼ぶ）。
+// 範囲外の値（0 や負の値、uint8 に収まらない値など）は argon2.IDKey の panic や過大なメモリ確保につながる。
+func CheckPasswordHashSettings() error {
+	var errs []error
+	for _, s := range passwordHashSettings {
+		if _, err := s.value(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// passwordHashParam は key のパラメータを返す。範囲外の場合はログに記録してデフォルト値を使う。
+func passwordHashParam(key string) int {
+	for _, s := range passwordHashSettings {
+		if s.key != key {
+			continue
+		}
+		v, err := s.value()
+		if err != nil {
+			log.Printf("Warning: %v。デフォルト値 %d を使用します", err, v)
+		}
+		return v
+	}
+	panic("unknown password hash setting: " + key)
+}
+
+// usesBcrypt は新規ハッシュに bcrypt を使う設定か（PASSWORD_HASH_ALGORITHM=bcrypt）を返す。
+func usesBcrypt() bool {
+	return config.GetEnv("PASSWORD_HASH_ALGORITHM", "argon2id") == "bcrypt"
+}
+
+// passwordHashers は検証に使うハッシュ方式の一覧（新規ハッシュは PASSWORD_HASH_ALGORITHM の方式）
+func passwordHashers() (current PasswordHasher, all []PasswordHasher) {
+	argon := argon2idHasher{
+		memory:      uint32(passwordHashParam("ARGON2_MEMORY_KIB")),
+		iterations:  uint32(passwordHashParam("ARGON2_ITERATIONS")),
+		parallelism: uint8(passwordHashParam("ARGON2_PARALLELISM")),
+		saltLength:  16,
+		keyLength:   32,
+	}
+	bc := bcryptHasher{cost: passwordHashParam("BCRYPT_COST")}
+
+	all = []PasswordHasher{argon, bc}
+	if usesBcrypt() {
+		return bc, all
+	}
+	return argon, all
+}
+
+// HashPassword は現在の設定のハッシュ方式でパスワードをハッシュ化する。
+func HashPassword(password string) (string, error) {
+	current, _ := passwordHashers()
+	return current.Hash(password)
+}
+
+// VerifyPassword はハッシュとパスワードを照合する。
+// 一致した場合、ハッシュ方式やパラメータが現在の設定と異なれば needsRehash が true になる。
+func VerifyPassword(encoded, password string) (ok bool, needsRehash bool, err error) {
+	current, all := passwordHashers()
+	for _, h := range all {
+		if !h.Handles(encoded) {
+			continue
+		}
+		ok, err = h.Verify(encoded, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, h != current || current.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownPasswordHash
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"k-cms/config"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// PasswordPolicyError はパスワードポリシー違反を表す（メッセージはそのままクライアントに返してよい）
+type PasswordPolicyError struct {
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+// breachedPasswords は漏洩パスワードリストを SHA-1 ハッシュ（大文字16進）の集合として保持する。
+// ファイルの更新時刻が変わったら読み込み直す。
+var breachedPasswords struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	hashes  map[string]struct{}
+}
+
+// loadBreachedPasswords は PASSWORD_BREACHED_LIST_PATH のファイルを読み込む。
+// 1行1件で、平文パスワードか SHA-1 ハッシュ（HIBP 形式の "HASH:件数" も可）を受け付ける。
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			hashes[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		hashes[sha1Hex(line)] = struct{}{}
+	}
+	return hashes, scanner.Err()
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// isBreachedPassword は漏洩パスワードリストに含まれるかを返す。
+func isBreachedPassword(password string) bool {
+	path := config.GetEnv("PASSWORD_BREACHED_LIST_PATH", "")
+	if path == "" {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Warning: 漏洩パスワードリストを参照できません (%s): %v", path, err)
+		return false
+	}
+
+	breachedPasswords.mu.RLock()
+	fresh := breachedPasswords.path == path && breachedPasswords.modTime.Equal(info.ModTime())
+	breachedPasswords.mu.RUnlock()
+
+	if !fresh {
+		hashes, err := loadBreachedPasswords(path)
+		if err != nil {
+			log.Printf("Warning: 漏洩パスワードリストの読み込みに失敗しました (%s): %v", path, err)
+			return false
+		}
+		breachedPasswords.mu.Lock()
+		breachedPasswords.path = path
+		breachedPasswords.modTime = info.ModTime()
+		breachedPasswords.hashes = hashes
+		breachedPasswords.mu.Unlock()
+		log.Printf("漏洩パスワードリストを読み込みました: %s (%d件)", path, len(hashes))
+	}
+
+	breachedPasswords.mu.RLock()
+	defer breachedPasswords.mu.RUnlock()
+	_, found := breachedPasswords.hashes[sha1Hex(password)]
+	return found
+}
+
+// ValidatePasswordPolicy はパスワードが長さ制限と漏洩パスワードリストの条件を満たすか検証する。
+func ValidatePasswordPolicy(password string) error {
+	minLength := config.GetEnvInt("PASSWORD_MIN_LENGTH", 8)
+	maxLength := config.GetEnvInt("PASSWORD_MAX_LENGTH", 128)
+
+	length := utf8.RuneCountInString(password)
+	if length < minLength {
+		return &PasswordPolicyError{Message: fmt.Sprintf("パスワードは%d文字以上にしてください", minLength)}
+	}
+	if length > maxLength {
+		return &PasswordPolicyError{Message: fmt.Sprintf("パスワードは%d文字以下にしてください", maxLength)}
+	}
+	// bcrypt は72バイトを超える部分を無視するため、それより長いパスワードは受け付けない
+	if usesBcrypt() && len(password) > bcryptMaxPasswordBytes {
+		return &PasswordPolicyError{Message: fmt.Sprintf("パスワードは%dバイト以下にしてください（全角文字は1文字3バイト）", bcryptMaxPasswordBytes)}
+	}
+	if isBreachedPassword(password) {
+		return &PasswordPolicyError{Message: "このパスワードは漏洩済みのパスワードとして知られているため使用できません"}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"errors"
+	"k-cms/config"
+	"k-cms/models"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// プレビュートークンの JWT に設定する purpose クレーム（ログイン用トークンとして使えないよう user_id は含めない）
+const previewTokenPurpose = "article_preview"
+
+var (
+	ErrPreviewTokenInvalid = errors.New("invalid preview token")
+	ErrPreviewTokenRevoked = errors.New("preview token has been revoked")
+)
+
+// PreviewTokenTTL はプレビューリンクの有効期限を返す。
+// ttl が0以下なら PREVIEW_TOKEN_TTL（デフォルト7日）、PREVIEW_TOKEN_MAX_TTL（デフォルト30日）を上限とする。
+func PreviewTokenTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = config.GetEnvDuration("PREVIEW_TOKEN_TTL", 7*24*time.Hour)
+	}
+	if max := config.GetEnvDuration("PREVIEW_TOKEN_MAX_TTL", 30*24*time.Hour); ttl > max {
+		ttl = max
+	}
+	return ttl
+}
+
+// IssuePreviewToken は記事のプレビュートークンを発行し、発行記録と署名済みトークンを返す。
+// トークンは JWT の鍵セットで署名されるため、署名鍵が退役するとそれ以前のリンクも無効になる。
+func IssuePreviewToken(articleID, createdBy uuid.UUID, ttl time.Duration, note string) (*models.ArticlePreviewToken, string, error) {
+	record := models.ArticlePreviewToken{
+		ID:        models.NewUUIDv7(),
+		ArticleID: articleID,
+		CreatedBy: createdBy,
+		Note:      note,
+		ExpiresAt: time.Now().Add(PreviewTokenTTL(ttl)),
+	}
+
+	token, err := SignAuthToken(jwt.MapClaims{
+		"purpose":    previewTokenPurpose,
+		"jti":        record.ID.String(),
+		"article_id": articleID.String(),
+		"iat":        time.Now().Unix(),
+		"exp":        record.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := config.DB.Create(&record).Error; err != nil {
+		return nil, "", err
+	}
+	return &record, token, nil
+}
+
+// ResolvePreviewToken はプレビュートークンを検証し、発行記録を返す。
+// 署名・期限・purpose のいずれかが不正なら ErrPreviewTokenInvalid、失効済みなら ErrPreviewTokenRevoked を返す。
+func ResolvePreviewToken(tokenString string) (*models.ArticlePreviewToken, error) {
+	token, err := ParseAuthToken(tokenString)
+	if err != nil || !token.Valid {
+		return nil, ErrPreviewTokenInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrPreviewTokenInvalid
+	}
+	if purpose, _ := claims["purpose"].(string); purpose != previewTokenPurpose {
+		return nil, ErrPreviewTokenInvalid
+	}
+	jti, _ := claims["jti"].(string)
+	articleID, _ := claims["article_id"].(string)
+
+	var record models.ArticlePreviewToken
+	if err := config.DB.Where("id = ?", jti).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPreviewTokenInvalid
+		}
+		return nil, err
+	}
+	if record.ArticleID.String() != articleID {
+		return nil, ErrPreviewTokenInvalid
+	}
+	if record.RevokedAt != nil {
+		return nil, ErrPreviewTokenRevoked
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, ErrPreviewTokenInvalid
+	}
+
+	now := time.Now()
+	config.DB.Model(&record).UpdateColumns(map[string]interface{}{
+		"last_accessed_at": now,
+		"access_count":     gorm.Expr("access_count + 1"),
+	})
+	record.LastAccessedAt = &now
+	record.AccessCount++
+	return &record, nil
+}
+
+// RevokePreviewToken はプレビュートークンを失効させる。既に失効済みの場合は何もしない。
+func RevokePreviewToken(record *models.ArticlePreviewToken, revokedBy *uuid.UUID) error {
+	if record.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	if err := config.DB.Model(record).Updates(map[string]interface{}{
+		"revoked_at": now,
+		"revoked_by": revokedBy,
+	}).Error; err != nil {
+		return err
+	}
+	record.RevokedAt = &now
+	record.RevokedBy = revokedBy
+	return nil
+}
+
+// PreviewURL は PREVIEW_BASE_URL が設定されていればフロントエンドのプレビューページの URL を返す（例: https://www.katori.dev/preview）。
+func PreviewURL(token string) string {
+	base := config.GetEnv("PREVIEW_BASE_URL", "")
+	if base == "" {
+		return ""
+	}
+	return strings.TrimRight(base, "/") + "/" + token
+}
@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"k-cms/config"
+	"k-cms/models"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// page_views / likes に保存する IP アドレスの形式（PRIVACY_IP_MODE）
+const (
+	PrivacyIPModeRaw      = "raw"      // そのまま保存する
+	PrivacyIPModeHash     = "hash"     // 日ごとのソルトで HMAC-SHA256 したもの（デフォルト、同じ日の同一 IP のみ識別できる）
+	PrivacyIPModeTruncate = "truncate" // IPv4 は /24、IPv6 は /48 に切り詰めたもの
+)
+
+// 保持期間を過ぎた page_views の扱い（PRIVACY_RETENTION_ACTION）
+const (
+	RetentionActionAnonymize = "anonymize" // fingerprint・IP アドレス・User-Agent を消して流入元などの集計用の項目だけ残す（デフォルト）
+	RetentionActionDelete    = "delete"    // 行ごと削除する（日次集計には残る）
+)
+
+// 保持期間の処理を1回の UPDATE / DELETE で扱う件数（長時間のロックを避けるため分割する）
+const retentionBatchSize = 5000
+
+var dailySaltCache struct {
+	mu   sync.Mutex
+	date string
+	salt []byte
+}
+
+func privacyIPMode() string {
+	switch mode := config.GetEnv("PRIVACY_IP_MODE", PrivacyIPModeHash); mode {
+	case PrivacyIPModeRaw, PrivacyIPModeTruncate:
+		return mode
+	default:
+		return PrivacyIPModeHash
+	}
+}
+
+// dailySalt は今日のソルトを返す。複数のプロセスで同じソルトを使うよう DB に保存し、無ければ作成する。
+func dailySalt(now time.Time) ([]byte, error) {
+	date := now.Format(statsDateLayout)
+
+	dailySaltCache.mu.Lock()
+	defer dailySaltCache.mu.Unlock()
+	if dailySaltCache.date == date {
+		return dailySaltCache.salt, nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	// 他のプロセスが先に作成していればそちらを使う
+	if err := config.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.PrivacySalt{Date: startOfDay(now), Salt: hex.EncodeToString(buf)}).Error; err != nil {
+		return nil, err
+	}
+	var row models.PrivacySalt
+	if err := config.DB.Where("date = ?", date).First(&row).Error; err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(row.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	dailySaltCache.date = date
+	dailySaltCache.salt = salt
+	return salt, nil
+}
+
+// truncateIP は IPv4 を /24、IPv6 を /48 に切り詰める。解釈できない値は空文字にする。
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// AnonymizeIP は PRIVACY_IP_MODE に従って page_views / likes に保存する IP アドレスを返す。
+// ハッシュは "h:" から始まる40文字の16進数で、ソルトを取得できない場合は生の IP アドレスを残さないよう切り詰める。
+func AnonymizeIP(ip string) string {
+	switch privacyIPMode() {
+	case PrivacyIPModeRaw:
+		return ip
+	case PrivacyIPModeTruncate:
+		return truncateIP(ip)
+	}
+
+	salt, err := dailySalt(time.Now())
+	if err != nil {
+		log.Printf("[Privacy] ソルトの取得に失敗したため IP アドレスを切り詰めて保存します: %v", err)
+		return truncateIP(ip)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	return "h:" + hex.EncodeToString(mac.Sum(nil))[:40]
+}
+
+// purgeOldSalts は今日より前のソルトを削除する。
+func purgeOldSalts() error {
+	return config.DB.Where("date < ?", time.Now().Format(statsDateLayout)).Delete(&models.PrivacySalt{}).Error
+}
+
+// retentionSettings は PRIVACY_RETENTION_DAYS（0以下で無効）と PRIVACY_RETENTION_ACTION を返す。
+func retentionSettings() (days int, action string) {
+	days = config.GetEnvInt("PRIVACY_RETENTION_DAYS", 90)
+	action = config.GetEnv("PRIVACY_RETENTION_ACTION", RetentionActionAnonymize)
+	if action != RetentionActionDelete {
+		action = RetentionActionAnonymize
+	}
+	return days, action
+}
+
+// deletedRawBefore は保持期間を過ぎた page_views を削除する設定の場合に、削除済みの範囲の境界を返す。
+// この日付より前は page_views が残っていないため、日次集計をやり直してはいけない。
+func deletedRawBefore() (time.Time, bool) {
+	days, action := retentionSettings()
+	if days <= 0 || action != RetentionActionDelete {
+		return time.Time{}, false
+	}
+	return startOfDay(time.Now()).AddDate(0, 0, -days), true
+}
+
+// RetentionResult は保持期間の処理で変更した件数
+type RetentionResult struct {
+	PageViewsAnonymized int64 `json:"page_views_anonymized"`
+	PageViewsDeleted    int64 `json:"page_views_deleted"`
+	LikesAnonymized     int64 `json:"likes_anonymized"`
+	LikesPurged         int64 `json:"likes_purged"` // 取り消されてから保持期間を過ぎたいいね
+	CommentsAnonymized  int64 `json:"comments_anonymized"`
+}
+
+// inBatches は1回あたり retentionBatchSize 件ずつ処理し、影響した件数の合計を返す。
+func inBatches(run func() *gorm.DB) (int64, error) {
+	var total int64
+	for {
+		result := run()
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < retentionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// ApplyPrivacyRetention は days 日より前の page_views を匿名化または削除し、いいね・コメントの IP アドレスと User-Agent を消す。
+// page_views は日次集計が確定した日付のものだけを対象にする（集計前の行を消すとカウントが減るため）。
+// いいねは取り消しに fingerprint が必要なため fingerprint は残し、取り消し済みのいいねは行ごと削除する。
+func ApplyPrivacyRetention(days int, action string) (RetentionResult, error) {
+	var result RetentionResult
+	if days <= 0 {
+		return result, nil
+	}
+	cutoff := startOfDay(time.Now()).AddDate(0, 0, -days)
+
+	if watermark, ok := getRollupWatermark(); !ok {
+		log.Println("[Privacy] 日次集計が未完了のため、page_views の保持期間の処理をスキップします")
+	} else {
+		pvCutoff := cutoff
+		if watermark.Before(pvCutoff) {
+			pvCutoff = watermark
+		}
+		boundary := pvCutoff.Format(statsDateLayout)
+
+		var err error
+		if action == RetentionActionDelete {
+			result.PageViewsDeleted, err = inBatches(func() *gorm.DB {
+				return config.DB.Unscoped().Where("visited_date < ?", boundary).Limit(retentionBatchSize).Delete(&models.PageView{})
+			})
+		} else {
+			result.PageViewsAnonymized, err = inBatches(func() *gorm.DB {
+				return config.DB.Unscoped().Model(&models.PageView{}).
+					Where("visited_date < ? AND fingerprint NOT LIKE ?", boundary, "anon-%").
+					Limit(retentionBatchSize).
+					Updates(map[string]interface{}{
+						// ユニーク制約 (article_id, fingerprint, visited_date) を保つため行の ID から作る
+						"fingerprint": gorm.Expr("CONCAT('anon-', id)"),
+						"ip_address":  "",
+						"user_agent":  "",
+					})
+			})
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	var err error
+	result.LikesAnonymized, err = inBatches(func() *gorm.DB {
+		return config.DB.Unscoped().Model(&models.Like{}).
+			Where("created_at < ? AND (ip_address <> '' OR user_agent <> '')", cutoff).
+			Limit(retentionBatchSize).
+			Updates(map[string]interface{}{"ip_address": "", "user_agent": ""})
+	})
+	if err != nil {
+		return result, err
+	}
+	result.LikesPurged, err = inBatches(func() *gorm.DB {
+		return config.DB.Unscoped().Where("deleted_at < ?", cutoff).Limit(retentionBatchSize).Delete(&models.Like{})
+	})
+	if err != nil {
+		return result, err
+	}
+	// コメントは投稿禁止の判定に fingerprint を使うため残す
+	result.CommentsAnonymized, err = inBatches(func() *gorm.DB {
+		return config.DB.Unscoped().Model(&models.Comment{}).
+			Where("created_at < ? AND (ip_address <> '' OR user_agent <> '')", cutoff).
+			Limit(retentionBatchSize).
+			Updates(map[string]interface{}{"ip_address": "", "user_agent": ""})
+	})
+	return result, err
+}
+
+// StartPrivacyRetention は1時間ごとに前日までのソルトを削除し、保持期間を過ぎたデータを匿名化・削除する。
+// PRIVACY_RETENTION_DAYS（デフォルト90）日を過ぎたものが対象で、0以下を指定した場合はソルトの削除のみ行う。
+func StartPrivacyRetention() {
+	go func() {
+		for {
+			if err := purgeOldSalts(); err != nil {
+				log.Printf("[Privacy] 古いソルトの削除に失敗: %v", err)
+			}
+
+			days, action := retentionSettings()
+			result, err := ApplyPrivacyRetention(days, action)
+			if err != nil {
+				log.Printf("[Privacy] 保持期間の処理に失敗: %v", err)
+			} else if result != (RetentionResult{}) {
+				log.Printf("[Privacy] 保持期間(%d日)を過ぎたデータを処理しました: page_views 匿名化=%d 削除=%d, likes 匿名化=%d 削除=%d, comments 匿名化=%d",
+					days, result.PageViewsAnonymized, result.PageViewsDeleted, result.LikesAnonymized, result.LikesPurged, result.CommentsAnonymized)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// EraseResult は fingerprint のデータ削除で削除した件数
+type EraseResult struct {
+	PageViews int64 `json:"page_views"`
+	Likes     int64 `json:"likes"`
+	Comments  int64 `json:"comments"`
+}
+
+var ErrInvalidFingerprint = errors.New("invalid fingerprint")
+
+// EraseFingerprintData は fingerprint に紐づく page_views・likes・comments を全て削除する（読者からの削除依頼用）。
+// 数えられていたいいねは like_count から差し引く。日次集計は個人を識別しないため変更しない。
+// 削除したコメントへの他の読者の返信は、親が無くなるため公開されなくなる。投稿禁止の記録は残す。
+func EraseFingerprintData(fingerprint string) (EraseResult, error) {
+	var result EraseResult
+	if strings.TrimSpace(fingerprint) == "" {
+		return result, ErrInvalidFingerprint
+	}
+
+	// 書き出し待ちの PV も破棄する（書き出し中のものは保存を待ってから下で削除する）
+	discardPendingPageViews(fingerprint)
+
+	var viewedArticles []string
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var counted []string
+		if err := tx.Model(&models.Like{}).
+			Where("fingerprint = ? AND is_bot = ? AND reaction = ?", fingerprint, false, models.DefaultReaction).
+			Pluck("article_id", &counted).Error; err != nil {
+			return err
+		}
+		for _, articleID := range counted {
+			if err := tx.Model(&models.Article{}).Where("id = ? AND like_count > 0", articleID).
+				Update("like_count", gorm.Expr("like_count - ?", 1)).Error; err != nil {
+				return err
+			}
+		}
+
+		likes := tx.Unscoped().Where("fingerprint = ?", fingerprint).Delete(&models.Like{})
+		if likes.Error != nil {
+			return likes.Error
+		}
+		// 削除後に PV 数を数え直す記事
+		if err := tx.Unscoped().Model(&models.PageView{}).Where("fingerprint = ?", fingerprint).
+			Distinct("article_id").Pluck("article_id", &viewedArticles).Error; err != nil {
+			return err
+		}
+		views := tx.Unscoped().Where("fingerprint = ?", fingerprint).Delete(&models.PageView{})
+		if views.Error != nil {
+			return views.Error
+		}
+		comments := tx.Unscoped().Where("fingerprint = ?", fingerprint).Delete(&models.Comment{})
+		if comments.Error != nil {
+			return comments.Error
+		}
+		result.Likes = likes.RowsAffected
+		result.PageViews = views.RowsAffected
+		result.Comments = comments.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return EraseResult{}, err
+	}
+
+	invalidateViewCounts(viewedArticles)
+	botVelocity.forget(fingerprint)
+	return result, nil
+}
+
+// FingerprintDigest は監査ログなどに fingerprint そのものを残さないためのダイジェストを返す。
+func FingerprintDigest(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:8])
+}
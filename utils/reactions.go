@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"k-cms/config"
+	"k-cms/models"
+	"regexp"
+)
+
+// リアクションの種類名として使える文字列（フロントエンドで絵文字などに対応付ける）
+var reactionNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ReactionTypes は受け付けるリアクションの種類を返す（REACTION_TYPES、カンマ区切り）。
+// 従来のいいね（models.DefaultReaction）は常に先頭に含める。
+func ReactionTypes() []string {
+	types := []string{models.DefaultReaction}
+	for _, r := range splitList(config.GetEnv("REACTION_TYPES", "like,heart,party,laugh,thinking,eyes")) {
+		if reactionNamePattern.MatchString(r) && !containsString(types, r) {
+			types = append(types, r)
+		}
+	}
+	return types
+}
+
+// IsValidReaction は設定されたリアクションの種類かを返す。
+func IsValidReaction(reaction string) bool {
+	return containsString(ReactionTypes(), reaction)
+}
+
+// ReactionCounts は記事ごとのリアクション数を種類別に返す（ボットと判定されたものは数えない）。
+// 設定から外した種類のリアクションも、記録が残っていれば含める。
+func ReactionCounts(articleIDs []string) (map[string]map[string]int64, error) {
+	counts := make(map[string]map[string]int64, len(articleIDs))
+	if len(articleIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		ArticleID string
+		Reaction  string
+		Count     int64
+	}
+	if err := config.DB.Model(&models.Like{}).
+		Select("article_id, reaction, COUNT(*) AS count").
+		Where("article_id IN ? AND is_bot = ?", articleIDs, false).
+		Group("article_id, reaction").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		if counts[r.ArticleID] == nil {
+			counts[r.ArticleID] = map[string]int64{}
+		}
+		counts[r.ArticleID][r.Reaction] = r.Count
+	}
+	return counts, nil
+}
+
+// ArticleReactionCounts は記事1件のリアクション数を種類別に返す。設定されている種類は0件でも含める。
+func ArticleReactionCounts(articleID string) (map[string]int64, error) {
+	all, err := ReactionCounts([]string{articleID})
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, r := range ReactionTypes() {
+		counts[r] = 0
+	}
+	for r, n := range all[articleID] {
+		counts[r] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,670 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"k-cms/config"
+	"k-cms/models"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+//go:embed themes/default
+var defaultThemeFS embed.FS
+
+// StaticTargetConfig は static タイプのターゲットの設定。
+// Output は出力先ディレクトリ（deploy を設定した場合はリリースディレクトリに出力するため不要）、
+// Theme は html/template のテーマディレクトリ（省略時は組み込みのテーマ）。
+type StaticTargetConfig struct {
+	Output  string `yaml:"output" toml:"output" json:"output"`
+	Theme   string `yaml:"theme" toml:"theme" json:"theme"`
+	BaseURL string `yaml:"base_url" toml:"base_url" json:"base_url"`
+}
+
+func (s *StaticTargetConfig) validate(hasDeploy bool) error {
+	if s.Output == "" && !hasDeploy {
+		return fmt.Errorf("static.output is required when deploy is not set")
+	}
+	if s.BaseURL == "" {
+		s.BaseURL = defaultSiteBaseURL
+	}
+	s.BaseURL = strings.TrimRight(s.BaseURL, "/")
+	if _, err := url.Parse(s.BaseURL); err != nil {
+		return fmt.Errorf("invalid static.base_url: %w", err)
+	}
+	return nil
+}
+
+// 公開サイトのURL（base_url の既定値）
+const defaultSiteBaseURL = "https://www.katori.dev"
+
+// テーマに含めるページテンプレート（それぞれ layout.html と組み合わせて描画する）
+var staticPageTemplates = []string{"index.html", "article.html", "tags.html", "tag.html", "about.html"}
+
+// 差分出力のために前回出力した記事とタグを記録するファイル
+const staticManifestFile = ".kcms-manifest.json"
+
+type staticManifest struct {
+	GeneratedAt time.Time           `json:"generated_at"`
+	Articles    map[string][]string `json:"articles"` // 記事ID -> タグ
+}
+
+// StaticExportOptions は静的エクスポートの実行内容
+type StaticExportOptions struct {
+	OutputDir string
+	Config    StaticTargetConfig
+	// ArticleIDs を指定し Incremental が true の場合は、その記事に関係するページ
+	// （記事ページ、一覧、タグページ、フィード、サイトマップ）のみを出力する
+	ArticleIDs  []string
+	Incremental bool
+	Log         func(string)
+}
+
+// staticArticle はテンプレートに渡す記事
+type staticArticle struct {
+	ID            string
+	Title         string
+	Excerpt       string
+	CoverImageURL string
+	OgImageURL    string
+	Tags          []string
+	Date          time.Time
+	HTML          template.HTML
+	URL           string
+}
+
+type staticTag struct {
+	Name  string
+	URL   string
+	Count int
+}
+
+type staticOwner struct {
+	Username   string
+	Bio        string
+	GithubUrl  string
+	TwitterUrl string
+	QiitaUrl   string
+	MisskeyUrl string
+}
+
+// staticPage はページテンプレートに渡すデータ
+type staticPage struct {
+	Site     models.SiteConfig
+	Owner    staticOwner
+	BaseURL  string
+	Title    string
+	URL      string // ページの絶対URL（canonical）
+	Articles []staticArticle
+	Article  *staticArticle
+	Tag      string
+	Tags     []staticTag
+}
+
+type staticExporter struct {
+	opts      StaticExportOptions
+	templates map[string]*template.Template
+	theme     fs.FS
+	site      models.SiteConfig
+	owner     staticOwner
+	articles  []staticArticle
+	byID      map[string]*staticArticle
+	tags      map[string][]staticArticle
+}
+
+func articleURL(id string) string { return "/articles/" + id + "/" }
+func tagURL(tag string) string    { return "/tags/" + url.PathEscape(tagSlug(tag)) + "/" }
+
+// tagSlug はタグをディレクトリ名として使える形にする（パス区切りなどは "-" に置き換える）。
+func tagSlug(tag string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', '?', '#', '%':
+			return '-'
+		}
+		if r < 0x20 || r == 0x7f {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(tag))
+	if slug == "" || strings.Trim(slug, ".") == "" {
+		return "-" + slug
+	}
+	return slug
+}
+
+// parseArticleDate は記事の日付（DATE 型を文字列で読み込んだもの）を解釈する。
+func parseArticleDate(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if len(s) >= 10 {
+		if t, err := time.ParseInLocation("2006-01-02", s[:10], time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ExportStaticSite は公開済みの記事（下書きを除き、日付が今日以前のもの）から静的サイトを出力する。
+func ExportStaticSite(ctx context.Context, opts StaticExportOptions) error {
+	if opts.Log == nil {
+		opts.Log = func(string) {}
+	}
+	e := &staticExporter{opts: opts}
+	if err := e.loadTheme(); err != nil {
+		return err
+	}
+	if err := e.loadContent(); err != nil {
+		return err
+	}
+
+	var manifest *staticManifest
+	if opts.Incremental && len(opts.ArticleIDs) > 0 {
+		manifest = e.readManifest()
+		if manifest == nil {
+			opts.Log("No previous manifest found; rendering all pages")
+		}
+	}
+
+	if manifest != nil {
+		if err := e.renderIncremental(ctx, manifest); err != nil {
+			return err
+		}
+	} else if err := e.renderAll(ctx); err != nil {
+		return err
+	}
+	return e.writeManifest()
+}
+
+func (e *staticExporter) loadTheme() error {
+	if e.opts.Config.Theme != "" {
+		e.theme = os.DirFS(e.opts.Config.Theme)
+	} else {
+		sub, err := fs.Sub(defaultThemeFS, "themes/default")
+		if err != nil {
+			return err
+		}
+		e.theme = sub
+	}
+
+	funcs := template.FuncMap{
+		"tagURL":  tagURL,
+		"absURL":  func(p string) string { return e.opts.Config.BaseURL + p },
+		"fmtDate": func(t time.Time) string { return t.Format("2006-01-02") },
+	}
+	e.templates = map[string]*template.Template{}
+	for _, name := range staticPageTemplates {
+		tmpl, err := template.New(name).Funcs(funcs).ParseFS(e.theme, "layout.html", name)
+		if err != nil {
+			return fmt.Errorf("theme: %w", err)
+		}
+		e.templates[name] = tmpl
+	}
+	return nil
+}
+
+func (e *staticExporter) loadContent() error {
+	if err := config.DB.First(&e.site).Error; err != nil {
+		// サイト設定が未作成の場合は空の設定で出力する
+		e.site = models.SiteConfig{}
+	}
+
+	var owner models.User
+	if err := config.DB.First(&owner).Error; err == nil {
+		e.owner = staticOwner{
+			Username:   owner.Username,
+			Bio:        owner.Bio,
+			GithubUrl:  owner.GithubUrl,
+			TwitterUrl: owner.TwitterUrl,
+			QiitaUrl:   owner.QiitaUrl,
+			MisskeyUrl: owner.MisskeyUrl,
+		}
+	}
+
+	var articles []models.Article
+	if err := config.DB.Where("status = ? AND datetime <= ?", models.ArticleStatusPublished, time.Now().Format("2006-01-02")).
+		Order("datetime desc").Order("created_at desc").Find(&articles).Error; err != nil {
+		return err
+	}
+
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+	e.byID = map[string]*staticArticle{}
+	e.tags = map[string][]staticArticle{}
+	e.articles = make([]staticArticle, 0, len(articles))
+	for _, a := range articles {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(a.Content), &buf); err != nil {
+			return fmt.Errorf("article %s: %w", a.ID, err)
+		}
+		e.articles = append(e.articles, staticArticle{
+			ID:            a.ID.String(),
+			Title:         a.Title,
+			Excerpt:       a.Excerpt,
+			CoverImageURL: a.CoverImageURL,
+			OgImageURL:    a.OgImageURL,
+			Tags:          append([]string{}, a.Tags...),
+			Date:          parseArticleDate(a.Datetime),
+			HTML:          template.HTML(buf.String()),
+			URL:           articleURL(a.ID.String()),
+		})
+	}
+	for i := range e.articles {
+		a := &e.articles[i]
+		e.byID[a.ID] = a
+		for _, tag := range a.Tags {
+			e.tags[tag] = append(e.tags[tag], *a)
+		}
+	}
+	return nil
+}
+
+func (e *staticExporter) page(title, pagePath string) staticPage {
+	return staticPage{
+		Site:    e.site,
+		Owner:   e.owner,
+		BaseURL: e.opts.Config.BaseURL,
+		Title:   title,
+		URL:     e.opts.Config.BaseURL + pagePath,
+	}
+}
+
+func (e *staticExporter) tagList() []staticTag {
+	tags := make([]staticTag, 0, len(e.tags))
+	for name, articles := range e.tags {
+		tags = append(tags, staticTag{Name: name, URL: tagURL(name), Count: len(articles)})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return tags
+}
+
+// renderAll は全ページを出力する。削除された記事やタグのページが残らないよう articles/ と tags/ は作り直す。
+func (e *staticExporter) renderAll(ctx context.Context) error {
+	for _, dir := range []string{"articles", "tags"} {
+		if err := os.RemoveAll(filepath.Join(e.opts.OutputDir, dir)); err != nil {
+			return err
+		}
+	}
+	if err := e.copyThemeAssets(); err != nil {
+		return err
+	}
+
+	for i := range e.articles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.renderArticle(&e.articles[i]); err != nil {
+			return err
+		}
+	}
+	for tag := range e.tags {
+		if err := e.renderTag(tag); err != nil {
+			return err
+		}
+	}
+	if err := e.renderFile("about/index.html", "about.html", e.page("About", "/about/")); err != nil {
+		return err
+	}
+	if err := e.renderListings(); err != nil {
+		return err
+	}
+	e.opts.Log(fmt.Sprintf("Rendered all pages: articles=%d tags=%d", len(e.articles), len(e.tags)))
+	return nil
+}
+
+// renderIncremental は変更された記事に関係するページのみを出力する。
+func (e *staticExporter) renderIncremental(ctx context.Context, manifest *staticManifest) error {
+	affectedTags := map[string]bool{}
+	for _, id := range e.opts.ArticleIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, tag := range manifest.Articles[id] {
+			affectedTags[tag] = true
+		}
+
+		a, ok := e.byID[id]
+		if !ok {
+			// 削除された（または下書き・公開日前の）記事はページを削除する
+			if err := os.RemoveAll(filepath.Join(e.opts.OutputDir, "articles", id)); err != nil {
+				return err
+			}
+			e.opts.Log("Removed article page: " + id)
+			continue
+		}
+		for _, tag := range a.Tags {
+			affectedTags[tag] = true
+		}
+		if err := e.renderArticle(a); err != nil {
+			return err
+		}
+	}
+
+	for tag := range affectedTags {
+		if _, ok := e.tags[tag]; !ok {
+			if err := os.RemoveAll(filepath.Join(e.opts.OutputDir, "tags", tagSlug(tag))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.renderTag(tag); err != nil {
+			return err
+		}
+	}
+	if err := e.renderListings(); err != nil {
+		return err
+	}
+	e.opts.Log(fmt.Sprintf("Rendered affected pages: articles=%d tags=%d", len(e.opts.ArticleIDs), len(affectedTags)))
+	return nil
+}
+
+func (e *staticExporter) renderArticle(a *staticArticle) error {
+	p := e.page(a.Title, a.URL)
+	p.Article = a
+	return e.renderFile(path.Join("articles", a.ID, "index.html"), "article.html", p)
+}
+
+func (e *staticExporter) renderTag(tag string) error {
+	p := e.page("#"+tag, tagURL(tag))
+	p.Tag = tag
+	p.Articles = e.tags[tag]
+	return e.renderFile(path.Join("tags", tagSlug(tag), "index.html"), "tag.html", p)
+}
+
+// renderListings は記事一覧、タグ一覧、フィード、サイトマップ、robots.txt を出力する（どの変更でも影響を受けるページ）。
+func (e *staticExporter) renderListings() error {
+	index := e.page(e.site.SiteTitle, "/")
+	index.Articles = e.articles
+	if err := e.renderFile("index.html", "index.html", index); err != nil {
+		return err
+	}
+
+	tags := e.page("Tags", "/tags/")
+	tags.Tags = e.tagList()
+	if err := e.renderFile("tags/index.html", "tags.html", tags); err != nil {
+		return err
+	}
+
+	for name, render := range map[string]func() ([]byte, error){
+		"feed.xml":    e.rssFeed,
+		"atom.xml":    e.atomFeed,
+		"sitemap.xml": e.sitemap,
+		"robots.txt":  e.robots,
+	} {
+		data, err := render()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := writeFileAtomic(filepath.Join(e.opts.OutputDir, name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *staticExporter) renderFile(rel, tmpl string, data staticPage) error {
+	var buf bytes.Buffer
+	if err := e.templates[tmpl].ExecuteTemplate(&buf, "layout.html", data); err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return writeFileAtomic(filepath.Join(e.opts.OutputDir, filepath.FromSlash(rel)), buf.Bytes())
+}
+
+// copyThemeAssets はテーマの assets/ ディレクトリを出力先にコピーする。
+func (e *staticExporter) copyThemeAssets() error {
+	return fs.WalkDir(e.theme, "assets", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == "assets" && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(e.theme, p)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(e.opts.OutputDir, filepath.FromSlash(p)), data)
+	})
+}
+
+// writeFileAtomic は一時ファイルに書き込んでから rename し、配信中のファイルが書きかけにならないようにする。
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (e *staticExporter) readManifest() *staticManifest {
+	data, err := os.ReadFile(filepath.Join(e.opts.OutputDir, staticManifestFile))
+	if err != nil {
+		return nil
+	}
+	var m staticManifest
+	if err := json.Unmarshal(data, &m); err != nil || m.Articles == nil {
+		return nil
+	}
+	return &m
+}
+
+func (e *staticExporter) writeManifest() error {
+	m := staticManifest{GeneratedAt: time.Now(), Articles: map[string][]string{}}
+	for _, a := range e.articles {
+		m.Articles[a.ID] = a.Tags
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(e.opts.OutputDir, staticManifestFile), data)
+}
+
+// フィードに含める記事数
+const staticFeedItems = 20
+
+func (e *staticExporter) feedArticles() []staticArticle {
+	if len(e.articles) > staticFeedItems {
+		return e.articles[:staticFeedItems]
+	}
+	return e.articles
+}
+
+func (e *staticExporter) rssFeed() ([]byte, error) {
+	type item struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		GUID        string `xml:"guid"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+	}
+	type channel struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		Items       []item `xml:"item"`
+	}
+	type rss struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Channel channel  `xml:"channel"`
+	}
+
+	feed := rss{Version: "2.0", Channel: channel{
+		Title:       e.site.SiteTitle,
+		Link:        e.opts.Config.BaseURL + "/",
+		Description: e.site.SiteDescription,
+	}}
+	for _, a := range e.feedArticles() {
+		link := e.opts.Config.BaseURL + a.URL
+		feed.Channel.Items = append(feed.Channel.Items, item{
+			Title:       a.Title,
+			Link:        link,
+			GUID:        link,
+			Description: a.Excerpt,
+			PubDate:     a.Date.Format(time.RFC1123Z),
+		})
+	}
+	return marshalXML(feed)
+}
+
+func (e *staticExporter) atomFeed() ([]byte, error) {
+	type link struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+	type entry struct {
+		Title   string `xml:"title"`
+		Link    link   `xml:"link"`
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Summary string `xml:"summary"`
+	}
+	type feed struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string   `xml:"title"`
+		ID      string   `xml:"id"`
+		Links   []link   `xml:"link"`
+		Updated string   `xml:"updated"`
+		Author  struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+		Entries []entry `xml:"entry"`
+	}
+
+	f := feed{
+		Title: e.site.SiteTitle,
+		ID:    e.opts.Config.BaseURL + "/",
+		Links: []link{
+			{Href: e.opts.Config.BaseURL + "/"},
+			{Href: e.opts.Config.BaseURL + "/atom.xml", Rel: "self"},
+		},
+		Updated: time.Now().Format(time.RFC3339),
+	}
+	f.Author.Name = e.owner.Username
+	for _, a := range e.feedArticles() {
+		href := e.opts.Config.BaseURL + a.URL
+		f.Entries = append(f.Entries, entry{
+			Title:   a.Title,
+			Link:    link{Href: href},
+			ID:      href,
+			Updated: a.Date.Format(time.RFC3339),
+			Summary: a.Excerpt,
+		})
+	}
+	return marshalXML(f)
+}
+
+func (e *staticExporter) sitemap() ([]byte, error) {
+	type u struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod,omitempty"`
+	}
+	type urlset struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []u      `xml:"url"`
+	}
+
+	base := e.opts.Config.BaseURL
+	set := urlset{URLs: []u{{Loc: base + "/"}, {Loc: base + "/about/"}, {Loc: base + "/tags/"}}}
+	for _, a := range e.articles {
+		set.URLs = append(set.URLs, u{Loc: base + a.URL, LastMod: a.Date.Format("2006-01-02")})
+	}
+	for _, t := range e.tagList() {
+		set.URLs = append(set.URLs, u{Loc: base + t.URL})
+	}
+	return marshalXML(set)
+}
+
+func (e *staticExporter) robots() ([]byte, error) {
+	if !e.site.RobotIndex {
+		return []byte("User-agent: *\nDisallow: /\n"), nil
+	}
+	return []byte("User-agent: *\nAllow: /\n\nSitemap: " + e.opts.Config.BaseURL + "/sitemap.xml\n"), nil
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	io.WriteString(&buf, xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// copyDir は src 以下のファイルを dst にコピーする（差分出力で前回のリリースを引き継ぐために使用する）。
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"fmt"
+	"k-cms/config"
+	"k-cms/models"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 端末の種類
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// 流入元の分類（参照元ホストから判定する）
+const (
+	TrafficSourceDirect   = "direct"   // 参照元なし（ブックマーク、アプリ内ブラウザなど）
+	TrafficSourceInternal = "internal" // サイト内の遷移
+	TrafficSourceSearch   = "search"
+	TrafficSourceReferral = "referral" // 上記以外のサイト
+)
+
+// 参照元ホストと流入元の対応（ホスト名が一致するか、そのサブドメインなら該当する）
+var referrerSources = []struct {
+	source string
+	hosts  []string
+}{
+	{"x", []string{"x.com", "twitter.com", "t.co"}},
+	{"misskey", []string{"misskey.io", "misskey.dev", "misskey.design"}},
+	{"qiita", []string{"qiita.com"}},
+	{"zenn", []string{"zenn.dev"}},
+	{"hatena", []string{"b.hatena.ne.jp"}},
+	{"github", []string{"github.com"}},
+	{TrafficSourceSearch, []string{"google.com", "google.co.jp", "bing.com", "duckduckgo.com", "search.yahoo.co.jp", "search.yahoo.com", "ecosia.org", "search.brave.com", "yandex.ru", "baidu.com"}},
+}
+
+func hostMatches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// siteHosts は自サイトのホスト名（ANALYTICS_SITE_HOSTS、カンマ区切り）を返す。
+func siteHosts() []string {
+	return splitList(config.GetEnv("ANALYTICS_SITE_HOSTS", "www.katori.dev,katori.dev"))
+}
+
+// ClassifyReferrer は参照元 URL からホスト名と流入元の分類を返す。
+func ClassifyReferrer(referrer string) (host, source string) {
+	if referrer == "" {
+		return "", TrafficSourceDirect
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return "", TrafficSourceDirect
+	}
+	host = strings.ToLower(u.Hostname())
+
+	for _, h := range siteHosts() {
+		if host == strings.ToLower(h) {
+			return host, TrafficSourceInternal
+		}
+	}
+	for _, rs := range referrerSources {
+		for _, domain := range rs.hosts {
+			if hostMatches(host, domain) {
+				return host, rs.source
+			}
+		}
+	}
+	// Misskey は独自ドメインのサーバーも多いため、ホスト名にmisskeyを含むものも該当させる
+	if strings.Contains(host, "misskey") {
+		return host, "misskey"
+	}
+	return host, TrafficSourceReferral
+}
+
+// ParseUTM はページ URL のクエリから utm_source / utm_medium / utm_campaign を取り出す。
+func ParseUTM(pageURL string) (source, medium, campaign string) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", "", ""
+	}
+	q := u.Query()
+	return q.Get("utm_source"), q.Get("utm_medium"), q.Get("utm_campaign")
+}
+
+// PrimaryLanguage は Accept-Language などの言語指定から最初の言語タグを小文字で返す（例: "ja", "en-us"）。
+func PrimaryLanguage(value string) string {
+	tag, _, _ := strings.Cut(value, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "*" || len(tag) > 16 {
+		return ""
+	}
+	return tag
+}
+
+// ParseUserAgent は User-Agent から端末の種類とブラウザ名を判定する（主要なものだけを見る簡易的な判定）。
+func ParseUserAgent(ua string) (device, browser string) {
+	if ua == "" {
+		return DeviceUnknown, "Other"
+	}
+	lower := strings.ToLower(ua)
+
+	switch {
+	case strings.Contains(lower, "bot") || strings.Contains(lower, "crawler") || strings.Contains(lower, "spider"):
+		device = DeviceBot
+	case strings.Contains(lower, "ipad") || strings.Contains(lower, "tablet") ||
+		(strings.Contains(lower, "android") && !strings.Contains(lower, "mobile")):
+		device = DeviceTablet
+	case strings.Contains(lower, "mobi") || strings.Contains(lower, "iphone"):
+		device = DeviceMobile
+	case strings.Contains(lower, "windows") || strings.Contains(lower, "macintosh") ||
+		strings.Contains(lower, "x11") || strings.Contains(lower, "cros"):
+		device = DeviceDesktop
+	default:
+		device = DeviceUnknown
+	}
+
+	// 他のブラウザも "Chrome/" や "Safari/" を含むため、固有のトークンから順に判定する
+	switch {
+	case strings.Contains(ua, "Edg/") || strings.Contains(ua, "EdgiOS/") || strings.Contains(ua, "EdgA/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/") || strings.Contains(ua, "Opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "SamsungBrowser/"):
+		browser = "Samsung Internet"
+	case strings.Contains(ua, "Vivaldi/"):
+		browser = "Vivaldi"
+	case strings.Contains(ua, "Firefox/") || strings.Contains(ua, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/") || strings.Contains(ua, "CriOS/") || strings.Contains(ua, "Chromium/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	default:
+		browser = "Other"
+	}
+	return device, browser
+}
+
+// 内訳の集計に使える項目と page_views のカラムの対応
+var breakdownColumns = map[string]string{
+	"source":       "source",
+	"referrer":     "referrer_host",
+	"utm_source":   "utm_source",
+	"utm_medium":   "utm_medium",
+	"utm_campaign": "utm_campaign",
+	"device":       "device_class",
+	"browser":      "browser",
+	"language":     "language",
+}
+
+// BreakdownRow は内訳の1項目分
+type BreakdownRow struct {
+	Key   string `json:"key"` // 値が記録されていない場合は空文字
+	Views int64  `json:"views"`
+}
+
+// TrafficBreakdown は from から to まで（両端を含む日付）の PV を dimension ごとに数え、多い順に返す。
+// articleID が空の場合はサイト全体を集計する。ボットと判定されたアクセスは含めない。
+func TrafficBreakdown(articleID, dimension string, from, to time.Time, limit int) ([]BreakdownRow, error) {
+	column, ok := breakdownColumns[dimension]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown dimension %q", ErrInvalidStatsRange, dimension)
+	}
+	if startOfDay(to).Before(startOfDay(from)) {
+		return nil, ErrInvalidStatsRange
+	}
+
+	query := config.DB.Model(&models.PageView{}).
+		Select(column+" AS `key`, COUNT(*) AS views").
+		Where("is_bot = ? AND visited_date BETWEEN ? AND ?", false, startOfDay(from).Format(statsDateLayout), startOfDay(to).Format(statsDateLayout))
+	if articleID != "" {
+		query = query.Where("article_id = ?", articleID)
+	}
+
+	rows := []BreakdownRow{}
+	err := query.Group(column).Order("views DESC").Limit(limit).Scan(&rows).Error
+	return rows, err
+}